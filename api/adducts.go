@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Adduct describes how a neutral molecule M is observed as an ion, with
+// m/z = (Multimer * M + MassShift) / |Charge|. The sign of Charge decides the ionization mode the adduct belongs to
+type Adduct struct {
+	Name      string  `json:"name"`
+	Charge    int     `json:"charge"`
+	Multimer  int     `json:"multimer"`
+	MassShift float64 `json:"mass_shift"`
+}
+
+// defaultAdducts are the common ESI adducts, mass shifts are monoisotopic and
+// already account for the electron mass
+var defaultAdducts = []Adduct{
+	// Positive mode
+	{Name: "[M+H]+", Charge: 1, Multimer: 1, MassShift: 1.007276},
+	{Name: "[M+NH4]+", Charge: 1, Multimer: 1, MassShift: 18.033823},
+	{Name: "[M+Na]+", Charge: 1, Multimer: 1, MassShift: 22.989218},
+	{Name: "[M+K]+", Charge: 1, Multimer: 1, MassShift: 38.963158},
+	{Name: "[M+H-H2O]+", Charge: 1, Multimer: 1, MassShift: -17.003289},
+	{Name: "[M+2H]2+", Charge: 2, Multimer: 1, MassShift: 2.014552},
+	{Name: "[2M+H]+", Charge: 1, Multimer: 2, MassShift: 1.007276},
+	{Name: "[2M+NH4]+", Charge: 1, Multimer: 2, MassShift: 18.033823},
+	{Name: "[2M+Na]+", Charge: 1, Multimer: 2, MassShift: 22.989218},
+
+	// Negative mode
+	{Name: "[M-H]-", Charge: -1, Multimer: 1, MassShift: -1.007276},
+	{Name: "[M+Cl]-", Charge: -1, Multimer: 1, MassShift: 34.969402},
+	{Name: "[M+FA-H]-", Charge: -1, Multimer: 1, MassShift: 44.998201},
+	{Name: "[M+Hac-H]-", Charge: -1, Multimer: 1, MassShift: 59.013851},
+	{Name: "[M-H2O-H]-", Charge: -1, Multimer: 1, MassShift: -19.017841},
+	{Name: "[M-2H]2-", Charge: -2, Multimer: 1, MassShift: -2.014552},
+	{Name: "[2M-H]-", Charge: -1, Multimer: 2, MassShift: -1.007276},
+	{Name: "[2M+FA-H]-", Charge: -1, Multimer: 2, MassShift: 44.998201},
+}
+
+// adducts is the active adduct table, replaced at startup by LoadAdducts
+var adducts = defaultAdducts
+
+// neutralMass converts an observed m/z back to the mass of M
+func (a Adduct) neutralMass(mz float64) float64 {
+	return (mz*float64(abs(a.Charge)) - a.MassShift) / float64(a.Multimer)
+}
+
+// mz is the m/z a neutral molecule of the given mass is observed at
+func (a Adduct) mz(mass float64) float64 {
+	return (float64(a.Multimer)*mass + a.MassShift) / float64(abs(a.Charge))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (a Adduct) validate() error {
+	switch {
+	case strings.TrimSpace(a.Name) == "":
+		return errors.New("adduct name is empty")
+	case strings.ContainsAny(a.Name, " ,"):
+		return fmt.Errorf("adduct %q: name cannot contain spaces or commas", a.Name)
+	case a.Charge == 0:
+		return fmt.Errorf("adduct %q: charge cannot be 0", a.Name)
+	case a.Multimer < 1:
+		return fmt.Errorf("adduct %q: multimer must be at least 1", a.Name)
+	}
+	return nil
+}
+
+// LoadAdducts reads a JSON list of adducts and merges it into the default table,
+// entries with the name of a default adduct replace it
+func LoadAdducts(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read adducts file: %w", err)
+	}
+	var custom []Adduct
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("failed to parse adducts file: %w", err)
+	}
+
+	merged := slices.Clone(defaultAdducts)
+	for _, a := range custom {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if i := slices.IndexFunc(merged, func(d Adduct) bool { return d.Name == a.Name }); i >= 0 {
+			merged[i] = a
+		} else {
+			merged = append(merged, a)
+		}
+	}
+	adducts = merged
+	log.Printf("Loaded %d custom adducts from %s (%d total)", len(custom), path, len(adducts))
+	return nil
+}
+
+// selectAdducts returns the adducts of an ionization mode, optionally restricted to
+// a comma separated list of names
+func selectAdducts(ionMode, names string) ([]Adduct, error) {
+	var positive bool
+	switch strings.ToLower(ionMode) {
+	case "", "positive", "pos", "+":
+		positive = true
+	case "negative", "neg", "-":
+		positive = false
+	default:
+		return nil, fmt.Errorf("Invalid ion_mode %q, must be positive or negative", ionMode)
+	}
+
+	var wanted []string
+	if names != "" {
+		// Unencoded '+' in a URL decodes to a space, adduct names never contain spaces
+		wanted = strings.Split(strings.ReplaceAll(names, " ", "+"), ",")
+	}
+
+	var selected []Adduct
+	for _, a := range adducts {
+		if (a.Charge > 0) != positive {
+			continue
+		}
+		if wanted != nil && !slices.Contains(wanted, a.Name) {
+			continue
+		}
+		selected = append(selected, a)
+	}
+	for _, n := range wanted {
+		if !slices.ContainsFunc(selected, func(a Adduct) bool { return a.Name == n }) {
+			return nil, fmt.Errorf("Unknown adduct %q for the selected ion_mode", n)
+		}
+	}
+	return selected, nil
+}
+
+// Adducts lists the active adduct table
+func Adducts(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(adducts); err != nil {
+		log.Printf("Failed to encode adducts response: %v", err)
+	}
+}
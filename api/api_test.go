@@ -1,17 +1,21 @@
 package api
 
 import (
+	"context"
 	"ctslite/model"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -517,6 +521,8 @@ func TestCSVFormatResponse(t *testing.T) {
 	expectedData := []string{
 		"O", "smiles", "", "true", "Exact SMILES", "",
 		"1", "MYFAKEINCHIKEY-ISRIGHTHER-E", "InChI=1S/H2O/h1H2", "O", "Water", "H2O", "100", "10", "2",
+		"", "", "", "", "", "", "", // the test dataset has no properties
+		"", // nor libraries
 	}
 	if diff := cmp.Diff(expectedData, records[1]); diff != "" {
 		t.Errorf("CSV data row mismatch (-want +got):\n%s", diff)
@@ -861,20 +867,20 @@ func TestClassyFireCSVHeader(t *testing.T) {
 		"classyfire_error",
 	}
 	header := records[0]
-	if len(header) != 22 {
-		t.Fatalf("expected 22 CSV columns with classyfire enabled, got %d", len(header))
+	if len(header) != 30 {
+		t.Fatalf("expected 30 CSV columns with classyfire enabled, got %d", len(header))
 	}
 	for i, want := range wantSuffix {
-		got := header[15+i]
+		got := header[23+i]
 		if got != want {
-			t.Errorf("header[%d]: want %q, got %q", 15+i, want, got)
+			t.Errorf("header[%d]: want %q, got %q", 23+i, want, got)
 		}
 	}
 
 	// Data row must contain ClassyFire values
 	row := records[1]
-	if row[15] != "Organic compounds" {
-		t.Errorf("classyfire_kingdom: want %q, got %q", "Organic compounds", row[15])
+	if row[23] != "Organic compounds" {
+		t.Errorf("classyfire_kingdom: want %q, got %q", "Organic compounds", row[23])
 	}
 }
 
@@ -889,8 +895,8 @@ func TestClassyFireCSVNoExtraColumnsWhenDisabled(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to parse CSV: %v", err)
 	}
-	if len(records[0]) != 15 {
-		t.Errorf("expected 15 columns without classyfire, got %d", len(records[0]))
+	if len(records[0]) != 23 {
+		t.Errorf("expected 23 columns without classyfire, got %d", len(records[0]))
 	}
 }
 
@@ -910,8 +916,9 @@ func TestMatchErrorPaths(t *testing.T) {
 		{"smiles_direct", `{"queries":"C=O"}`},
 		{"formula", `{"queries":"H2O"}`},
 		{"smiles_or_formula", `{"queries":"CH4"}`},
-		// Numeric query -> type pubchem_id -> matchPubChemID
+		// Numeric query -> type pubchem_id -> matchPubChemIDs
 		{"pubchem_id", `{"queries":"1"}`},
+		{"mass", `{"queries":"mass:100"}`},
 	}
 
 	for _, tc := range cases {
@@ -999,13 +1006,13 @@ func TestClassyFireCSVNoMatchRowHasEmptyColumns(t *testing.T) {
 		t.Fatalf("expected header + no-match row, got %d rows", len(records))
 	}
 	row := records[1]
-	if len(row) != 22 {
-		t.Fatalf("expected 22 columns on the no-match row, got %d", len(row))
+	if len(row) != 30 {
+		t.Fatalf("expected 30 columns on the no-match row, got %d", len(row))
 	}
 	if row[3] != "false" {
 		t.Errorf("expected found_match=false, got %q", row[3])
 	}
-	for i := 15; i < 22; i++ {
+	for i := 23; i < 30; i++ {
 		if row[i] != "" {
 			t.Errorf("classyfire column %d should be empty on a no-match, got %q", i, row[i])
 		}
@@ -1026,7 +1033,7 @@ func TestStreamMatchResultsCapsAndStreams(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodPost, "/match?classyfire=true&stream=true", nil)
 	w := httptest.NewRecorder()
-	streamMatchResults(w, req, results)
+	streamMatchResults(w, req, results, nil, 0)
 
 	type streamMsg struct {
 		Type    string                `json:"type"`
@@ -1190,3 +1197,1041 @@ func TestSmilesOrFormulaViaInChIKeyFallback(t *testing.T) {
 	}
 	assertCompound(t, fakeWaterCompound(), results[0].Matches[0])
 }
+
+// privateIndex loads the test CSV into a private in-memory index. Some tests
+// reload the shared-cache database, so tests that count matches use their own copy.
+func privateIndex(t *testing.T) *model.PubChemIndex {
+	t.Helper()
+	idx, err := model.LoadCSVToPrivateMemory("../dataset/test_datasets/unittest_data.csv")
+	if err != nil {
+		t.Fatalf("failed to load index: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+// doMatchURL performs a POST match request against index with a custom URL (for request parameters)
+func doMatchURL(t *testing.T, index *model.PubChemIndex, url, payload string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	Match(index, w, req)
+	return w.Result()
+}
+
+func TestMassQuery(t *testing.T) {
+	index := privateIndex(t)
+
+	t.Run("default ppm tolerance", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?top_hit_only=false", `{"queries":"mass:100.0005"}`))
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		if results[0].QueryType != "mass" {
+			t.Errorf("expected query_type 'mass', got %q", results[0].QueryType)
+		}
+		if results[0].MatchLevel != "Mass Window" {
+			t.Errorf("expected match_level 'Mass Window', got %q", results[0].MatchLevel)
+		}
+		if len(results[0].Matches) != 1 {
+			t.Fatalf("expected 1 compound, got %d", len(results[0].Matches))
+		}
+		got := results[0].Matches[0]
+		if got.Identifier != "1" {
+			t.Errorf("expected Water, got %s", got.CompoundName)
+		}
+		if got.MassError == nil || *got.MassError < 4.9 || *got.MassError > 5.1 {
+			t.Errorf("expected mass error of ~5 ppm, got %v", got.MassError)
+		}
+	})
+
+	t.Run("da tolerance ranks by score", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?top_hit_only=false&da=0.6", `{"queries":"mass:99.5"}`))
+
+		if len(results[0].Matches) != 2 {
+			t.Fatalf("expected 2 compounds, got %d", len(results[0].Matches))
+		}
+		if results[0].Matches[0].CompoundName != "Methane" || results[0].Matches[1].CompoundName != "Water" {
+			t.Errorf("expected Methane then Water, got %s then %s",
+				results[0].Matches[0].CompoundName, results[0].Matches[1].CompoundName)
+		}
+	})
+
+	t.Run("outside ppm tolerance", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?ppm=1", `{"queries":"mass:100.0005"}`))
+
+		if results[0].MatchFound {
+			t.Error("expected no match outside 1 ppm")
+		}
+		if results[0].ErrMsg != "No compound found" {
+			t.Errorf("expected 'No compound found', got %q", results[0].ErrMsg)
+		}
+	})
+
+	t.Run("malformed mass", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"mass:abc"}`))
+
+		if results[0].ErrMsg != "Malformed mass, see documentation" {
+			t.Errorf("expected malformed mass error, got %q", results[0].ErrMsg)
+		}
+	})
+}
+
+func TestMassToleranceParams(t *testing.T) {
+	for _, params := range []string{"ppm=5&da=0.01", "ppm=abc", "da=-1"} {
+		t.Run(params, func(t *testing.T) {
+			res := doMatchURL(t, mockIndex, "/match?"+params, `{"queries":"mass:100"}`)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestMzQuery(t *testing.T) {
+	index := privateIndex(t)
+
+	t.Run("positive mode [M+H]+", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?top_hit_only=false", `{"queries":"mz:101.007276"}`))
+
+		if results[0].QueryType != "mz" {
+			t.Errorf("expected query_type 'mz', got %q", results[0].QueryType)
+		}
+		if results[0].MatchLevel != "Adduct m/z" {
+			t.Errorf("expected match_level 'Adduct m/z', got %q", results[0].MatchLevel)
+		}
+		if len(results[0].Matches) != 1 {
+			t.Fatalf("expected 1 compound, got %d", len(results[0].Matches))
+		}
+		got := results[0].Matches[0]
+		if got.CompoundName != "Water" || got.Adduct != "[M+H]+" {
+			t.Errorf("expected Water as [M+H]+, got %s as %s", got.CompoundName, got.Adduct)
+		}
+		if got.MassError == nil || math.Abs(*got.MassError) > 0.01 {
+			t.Errorf("expected ~0 ppm error, got %v", got.MassError)
+		}
+	})
+
+	t.Run("negative mode [M-H]-", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?ion_mode=negative", `{"queries":"mz:97.992724"}`))
+
+		if len(results[0].Matches) != 1 {
+			t.Fatalf("expected 1 compound, got %d", len(results[0].Matches))
+		}
+		if got := results[0].Matches[0]; got.CompoundName != "Methane" || got.Adduct != "[M-H]-" {
+			t.Errorf("expected Methane as [M-H]-, got %s as %s", got.CompoundName, got.Adduct)
+		}
+	})
+
+	t.Run("restricted adducts", func(t *testing.T) {
+		// Unencoded '+' decodes to a space and must still resolve to [M+Na]+
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?adducts=[M+Na]+", `{"queries":"mz:101.007276"}`))
+		if results[0].MatchFound {
+			t.Error("expected no match when only [M+Na]+ is searched")
+		}
+	})
+
+	t.Run("malformed m/z", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"mz:-5"}`))
+		if results[0].ErrMsg != "Malformed m/z, see documentation" {
+			t.Errorf("expected malformed m/z error, got %q", results[0].ErrMsg)
+		}
+	})
+
+	t.Run("adduct lookups keep the fields", func(t *testing.T) {
+		recorder := &massOptsIndex{CompoundIndex: index}
+		result := &model.SingleResult{}
+		opts := model.QueryOptions{Fields: model.FieldIdentifier | model.FieldExactMass, Rank: model.RankByScore}
+		matchMz(context.Background(), recorder, "mz:101.007276", result, massTolerance{ppm: defaultMassPpm}, defaultAdducts, opts)
+
+		if len(recorder.opts) == 0 {
+			t.Fatal("expected adduct lookups")
+		}
+		for _, got := range recorder.opts {
+			if got.Fields != opts.Fields {
+				t.Errorf("expected fields %v in adduct lookup, got %v", opts.Fields, got.Fields)
+			}
+		}
+	})
+}
+
+// massOptsIndex records the options of the mass lookups made through it
+type massOptsIndex struct {
+	model.CompoundIndex
+	opts []model.QueryOptions
+}
+
+func (idx *massOptsIndex) QueryByMass(ctx context.Context, mass, tolerance float64, opts model.QueryOptions) ([]*model.Compound, int, error) {
+	idx.opts = append(idx.opts, opts)
+	return idx.CompoundIndex.QueryByMass(ctx, mass, tolerance, opts)
+}
+
+func TestMzQueryParams(t *testing.T) {
+	for _, params := range []string{"ion_mode=sideways", "adducts=%5BM-H%5D-", "adducts=[M+Foo]%2B"} {
+		t.Run(params, func(t *testing.T) {
+			res := doMatchURL(t, mockIndex, "/match?"+params, `{"queries":"mz:101"}`)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestLoadAdducts(t *testing.T) {
+	orig := adducts
+	t.Cleanup(func() { adducts = orig })
+
+	path := t.TempDir() + "/adducts.json"
+	custom := `[{"name":"[M+Li]+","charge":1,"multimer":1,"mass_shift":7.015455},
+		{"name":"[M+H]+","charge":1,"multimer":1,"mass_shift":1.007276}]`
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatalf("failed to write adducts file: %v", err)
+	}
+	if err := LoadAdducts(path); err != nil {
+		t.Fatalf("LoadAdducts failed: %v", err)
+	}
+	if len(adducts) != len(defaultAdducts)+1 {
+		t.Errorf("expected %d adducts (one replaced, one added), got %d", len(defaultAdducts)+1, len(adducts))
+	}
+
+	results := parseMatchResults(t, doMatchURL(t, privateIndex(t), "/match", `{"queries":"mz:107.015455"}`))
+	if len(results[0].Matches) != 1 || results[0].Matches[0].Adduct != "[M+Li]+" {
+		t.Fatalf("expected a match via the custom [M+Li]+ adduct, got %+v", results[0])
+	}
+
+	for _, bad := range []string{`not json`, `[{"name":"[M]","charge":0,"multimer":1}]`, `[{"name":"","charge":1,"multimer":1}]`} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatalf("failed to write adducts file: %v", err)
+		}
+		if err := LoadAdducts(path); err == nil {
+			t.Errorf("expected error for adducts file %q", bad)
+		}
+	}
+}
+
+func TestNameQuery(t *testing.T) {
+	index := privateIndex(t)
+
+	tests := []struct {
+		name         string
+		query        string
+		wantType     string
+		wantLevel    string
+		wantCompound *model.Compound
+	}{
+		{"explicit exact name", "name:Methane", "name", "Exact Name", fakeMethaneCompound()},
+		{"explicit case-insensitive name", "name:METHANE", "name", "Case-insensitive Name", fakeMethaneCompound()},
+		{"lowercase query is a name", "water", "name", "Case-insensitive Name", fakeWaterCompound()},
+		// "Formaldehyde" starts with F, misses as a formula, then falls back to names
+		{"formula falls back to name", "Formaldehyde", "name", "Exact Name", fakeFormaldehyde()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"`+tc.query+`"}`))
+
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+			if !results[0].MatchFound {
+				t.Fatalf("expected a match, got error %q", results[0].ErrMsg)
+			}
+			if results[0].QueryType != tc.wantType {
+				t.Errorf("expected query_type %q, got %q", tc.wantType, results[0].QueryType)
+			}
+			if results[0].MatchLevel != tc.wantLevel {
+				t.Errorf("expected match_level %q, got %q", tc.wantLevel, results[0].MatchLevel)
+			}
+			assertCompound(t, tc.wantCompound, results[0].Matches[0])
+		})
+	}
+
+	t.Run("unknown name", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"caffeine"}`))
+		if results[0].MatchFound || results[0].ErrMsg != "No compound found" {
+			t.Errorf("expected 'No compound found', got %+v", results[0])
+		}
+	})
+}
+
+func TestBatchedQueriesKeepOrder(t *testing.T) {
+	index := privateIndex(t)
+
+	// Batched identifier types interleaved with per-query ones and a duplicate
+	queries := []string{
+		"2", "MYFAKEINCHIKEY-ISRIGHTHER-E", "CH4", "InChI=1S/CH2O/c1-2/h1H2",
+		"MYFAKEINCHIKEY-NOTINTHEDB-N", "999", "2",
+	}
+	wantIDs := []string{"2", "1", "2", "3", "2", "", "2"}
+	wantLevels := []string{"Exact PubChem ID", "Exact InChIKey", "Exact Formula", "Exact InChI", "First Block", "", "Exact PubChem ID"}
+
+	results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"`+strings.Join(queries, " ")+`"}`))
+	if len(results) != len(queries) {
+		t.Fatalf("expected %d results, got %d", len(queries), len(results))
+	}
+	for i, result := range results {
+		if result.Query != queries[i] {
+			t.Errorf("result %d: expected query %q, got %q", i, queries[i], result.Query)
+		}
+		if result.MatchLevel != wantLevels[i] {
+			t.Errorf("result %d: expected match_level %q, got %q", i, wantLevels[i], result.MatchLevel)
+		}
+		if wantIDs[i] == "" {
+			if result.MatchFound || result.ErrMsg != "No compound found" {
+				t.Errorf("result %d: expected 'No compound found', got %+v", i, result)
+			}
+			continue
+		}
+		if len(result.Matches) != 1 || result.Matches[0].Identifier != wantIDs[i] {
+			t.Errorf("result %d: expected a single match with ID %s, got %+v", i, wantIDs[i], result.Matches)
+		}
+	}
+}
+
+func TestRankParam(t *testing.T) {
+	index := privateIndex(t)
+
+	// Water (CID 1) and Methane (CID 2, higher counts) share the first block
+	tests := []struct {
+		rank     string
+		wantRank string
+		wantID   string
+	}{
+		{"", "score", "2"},
+		{"patent", "patent", "2"},
+		{"cid", "cid", "1"},
+		{"custom:0,0", "custom:0,0", "1"},
+		{"custom:0.2,0.8", "custom:0.2,0.8", "2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.wantRank, func(t *testing.T) {
+			results := parseMatchResults(t, doMatchURL(t, index, "/match?rank="+tc.rank, `{"queries":"MYFAKEINCHIKEY-NOTINTHEDB-N"}`))
+			if len(results) != 1 || !results[0].MatchFound {
+				t.Fatalf("expected a match, got %+v", results)
+			}
+			if results[0].Rank != tc.wantRank {
+				t.Errorf("expected rank %q, got %q", tc.wantRank, results[0].Rank)
+			}
+			if got := results[0].Matches[0].Identifier; got != tc.wantID {
+				t.Errorf("expected top hit %s, got %s", tc.wantID, got)
+			}
+		})
+	}
+
+	for _, rank := range []string{"popularity", "custom:1", "custom:-1,1", "custom:a,b"} {
+		res := doMatchURL(t, index, "/match?rank="+rank, `{"queries":"O"}`)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("rank %q: expected 400, got %d", rank, res.StatusCode)
+		}
+	}
+}
+
+func TestPagingParams(t *testing.T) {
+	index := privateIndex(t)
+
+	// Methane (CID 2) ranks before Water (CID 1) on the shared first block
+	tests := []struct {
+		params  string
+		wantIDs []string
+	}{
+		{"top_hit_only=false", []string{"2", "1"}},
+		{"top_hit_only=false&max_hits=1", []string{"2"}},
+		{"top_hit_only=false&offset=1", []string{"1"}},
+		{"offset=1", []string{"1"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.params, func(t *testing.T) {
+			// A 1 Da window around [M+H]+ of mass 100 holds both Water and Methane
+			url := "/match?adducts=[M%2BH]%2B&da=1&" + tc.params
+			results := parseMatchResults(t, doMatchURL(t, index, url, `{"queries":"MYFAKEINCHIKEY-NOTINTHEDB-N mz:101.007276"}`))
+			if len(results) != 2 {
+				t.Fatalf("expected 2 results, got %d", len(results))
+			}
+			// Batched first block lookup, and m/z hits merged across adducts
+			for _, result := range results {
+				var ids []string
+				for _, c := range result.Matches {
+					ids = append(ids, c.Identifier)
+				}
+				if diff := cmp.Diff(tc.wantIDs, ids); diff != "" {
+					t.Errorf("%s: page mismatch (-want +got):\n%s", result.Query, diff)
+				}
+				if result.TotalHits != 2 {
+					t.Errorf("%s: expected total_hits 2, got %d", result.Query, result.TotalHits)
+				}
+			}
+		})
+	}
+
+	for _, params := range []string{"max_hits=-1", "offset=x"} {
+		res := doMatchURL(t, index, "/match?"+params, `{"queries":"O"}`)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", params, res.StatusCode)
+		}
+	}
+}
+
+func TestProtonationMatches(t *testing.T) {
+	index := privateIndex(t)
+
+	// Water's key with another protonation flag, Methane shares only the first block
+	tests := []struct {
+		params    string
+		wantLevel string
+		wantID    string
+	}{
+		{"", "Protonation-insensitive InChIKey", "1"},
+		{"?protonation_matches=false", "First Block", "2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.wantLevel, func(t *testing.T) {
+			// Both the batched InChIKey lookups and the single one used for converted SMILES
+			original := smilesToInChIKey
+			smilesToInChIKey = func(string) (string, error) { return "MYFAKEINCHIKEY-ISRIGHTHER-N", nil }
+			t.Cleanup(func() { smilesToInChIKey = original })
+
+			results := parseMatchResults(t, doMatchURL(t, index, "/match"+tc.params, `{"queries":"MYFAKEINCHIKEY-ISRIGHTHER-N OCO"}`))
+			if len(results) != 2 {
+				t.Fatalf("expected 2 results, got %d", len(results))
+			}
+			for _, result := range results {
+				if !result.MatchFound {
+					t.Fatalf("%s: expected a match, got error %q", result.Query, result.ErrMsg)
+				}
+				if result.MatchLevel != tc.wantLevel {
+					t.Errorf("%s: expected match_level %q, got %q", result.Query, tc.wantLevel, result.MatchLevel)
+				}
+				if got := result.Matches[0].Identifier; got != tc.wantID {
+					t.Errorf("%s: expected top hit %s, got %s", result.Query, tc.wantID, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStereoInsensitiveInChI(t *testing.T) {
+	index := privateIndex(t)
+
+	results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"InChI=1S/CH2O/c1-2/h1H2/i1+1 InChI=1S/CH2O/c1-2/h1H2 InChI=1S/C2H6/c1-2/h1-2H3"}`))
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].MatchLevel != "InChI (stereo-insensitive)" || results[0].Matches[0].Identifier != "3" {
+		t.Errorf("expected a stereo-insensitive match with Formaldehyde, got %+v", results[0])
+	}
+	if results[1].MatchLevel != "Exact InChI" {
+		t.Errorf("expected an exact match to take precedence, got %q", results[1].MatchLevel)
+	}
+	if results[2].MatchFound || results[2].ErrMsg != "No compound found" {
+		t.Errorf("expected 'No compound found', got %+v", results[2])
+	}
+}
+
+func TestSynonymQuery(t *testing.T) {
+	index := privateIndex(t)
+	if _, err := index.DB().Exec(model.InsertSynonymSQL, "2", "Marsh gas"); err != nil {
+		t.Fatalf("failed to insert synonym: %v", err)
+	}
+
+	results := parseMatchResults(t, doMatchURL(t, index, "/match?split=newline", `{"queries":"name:Marsh gas"}`))
+	if len(results) != 1 || !results[0].MatchFound {
+		t.Fatalf("expected a match, got %+v", results)
+	}
+	if results[0].MatchLevel != "Exact Synonym" {
+		t.Errorf("expected match_level %q, got %q", "Exact Synonym", results[0].MatchLevel)
+	}
+	want := fakeMethaneCompound()
+	want.Synonym = "Marsh gas"
+	assertCompound(t, want, results[0].Matches[0])
+}
+
+func TestFormulaNormalization(t *testing.T) {
+	index := privateIndex(t)
+
+	tests := []struct {
+		query         string
+		wantType      string
+		wantConverted string
+	}{
+		{"H2O", "formula", ""},
+		{"OH2", "formula", "H2O"},
+		{"h2o", "formula", "H2O"},
+		{"H4C", "formula", "CH4"},
+		{"C H4", "formula", "CH4"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			results := parseMatchResults(t, doMatchURL(t, index, "/match?split=newline", `{"queries":"`+tc.query+`"}`))
+			if len(results) != 1 || !results[0].MatchFound {
+				t.Fatalf("expected a match, got %+v", results)
+			}
+			if results[0].QueryType != tc.wantType {
+				t.Errorf("expected query_type %q, got %q", tc.wantType, results[0].QueryType)
+			}
+			if results[0].ConvertedQuery != tc.wantConverted {
+				t.Errorf("expected converted_query %q, got %q", tc.wantConverted, results[0].ConvertedQuery)
+			}
+			if results[0].MatchLevel != "Exact Formula" {
+				t.Errorf("expected match_level %q, got %q", "Exact Formula", results[0].MatchLevel)
+			}
+		})
+	}
+
+	// Charged formulas are not mistaken for SMILES
+	results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"H3O+"}`))
+	if results[0].QueryType != "formula" {
+		t.Errorf("expected query_type formula for a charged formula, got %q", results[0].QueryType)
+	}
+}
+
+func TestSmilesOrFormulaFallback(t *testing.T) {
+	index := &formulaQueryIndex{CompoundIndex: privateIndex(t)}
+
+	// Missed SMILES are looked up as given, only queries that can't be SMILES are normalized
+	for query, want := range map[string]string{"OCC": "OCC", "CCO": "CCO", "C1CC1": "C1CC1", "OH2": "H2O", "C2O": "C2O", "NaCl": "ClNa"} {
+		index.formulas = nil
+		matchSmilesOrFormula(context.Background(), index, query, &model.SingleResult{}, true, true, model.QueryOptions{}, false)
+		if len(index.formulas) != 1 || index.formulas[0] != want {
+			t.Errorf("%s: expected a formula lookup of %s, got %v", query, want, index.formulas)
+		}
+	}
+}
+
+// formulaQueryIndex records the formulas looked up through it
+type formulaQueryIndex struct {
+	model.CompoundIndex
+	formulas []string
+}
+
+func (idx *formulaQueryIndex) QueryByFormula(ctx context.Context, formula string, opts model.QueryOptions) ([]*model.Compound, int, error) {
+	idx.formulas = append(idx.formulas, formula)
+	return idx.CompoundIndex.QueryByFormula(ctx, formula, opts)
+}
+
+func TestAromaticSmilesQueryType(t *testing.T) {
+	// Lowercase aromatic SMILES are not recased into formulas ("c1ccccc1" is not C6)
+	for _, q := range []string{"c1ccccc1", "c1ccncc1", "nc1ccccc1", "c1ccc2ccccc2c1", "c1ccccc1Cl"} {
+		if got := parseQueryType(q); got != "smiles" {
+			t.Errorf("parseQueryType(%q) = %q, want smiles", q, got)
+		}
+	}
+	for _, q := range []string{"h2o", "co2", "c6h12o6"} {
+		if got := parseQueryType(q); got != "formula" {
+			t.Errorf("parseQueryType(%q) = %q, want formula", q, got)
+		}
+	}
+}
+
+func TestFormulaRangeQuery(t *testing.T) {
+	index := privateIndex(t)
+
+	results := parseMatchResults(t, doMatchURL(t, index, "/match?split=newline", `{"queries":"formula:C1 H2-4 O0\nformula:C1-2H2-4\nformula:Na1\nformula:C5-9"}`))
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+
+	if results[0].QueryType != "formula_range" || results[0].MatchLevel != "Formula Range" {
+		t.Errorf("expected a formula_range query with match_level Formula Range, got %q and %q", results[0].QueryType, results[0].MatchLevel)
+	}
+	if len(results[0].Matches) != 1 {
+		t.Fatalf("expected methane only, got %d matches", len(results[0].Matches))
+	}
+	assertCompound(t, fakeMethaneCompound(), results[0].Matches[0])
+
+	// Ranked by score, methane before formaldehyde
+	if results[1].TotalHits != 2 || results[1].Matches[0].Identifier != "2" {
+		t.Errorf("expected methane then formaldehyde, got %+v", results[1].Matches)
+	}
+
+	if results[2].ErrMsg != "Malformed formula range, see documentation" {
+		t.Errorf("expected a malformed formula range error, got %q", results[2].ErrMsg)
+	}
+	if results[3].ErrMsg != "No compound found" {
+		t.Errorf("expected no compound found, got %q", results[3].ErrMsg)
+	}
+}
+
+func TestXrefQuery(t *testing.T) {
+	index := privateIndex(t)
+	for _, x := range [][3]string{{"1", model.XrefCAS, "7732-18-5"}, {"1", model.XrefChEBI, "CHEBI:15377"}, {"2", model.XrefKEGG, "C01438"}} {
+		if _, err := index.DB().Exec(model.InsertXrefSQL, x[0], x[1], x[2]); err != nil {
+			t.Fatalf("failed to insert xref: %v", err)
+		}
+	}
+
+	results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"7732-18-5 chebi:15377 C01438 7732-18-4 HMDB0000001 2"}`))
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(results))
+	}
+
+	tests := []struct {
+		queryType string
+		level     string
+		converted string
+		errMsg    string
+	}{
+		{"cas", "Exact CAS", "", ""},
+		{"chebi", "Exact ChEBI ID", "CHEBI:15377", ""},
+		{"kegg", "Exact KEGG ID", "", ""},
+		{"bad_cas", "", "", "Malformed CAS number, see documentation"},
+		{"hmdb", "", "", "No compound found"},
+		{"pubchem_id", "Exact PubChem ID", "", ""},
+	}
+	for i, tc := range tests {
+		got := results[i]
+		if got.QueryType != tc.queryType || got.MatchLevel != tc.level || got.ConvertedQuery != tc.converted || got.ErrMsg != tc.errMsg {
+			t.Errorf("%s: expected %q %q %q %q, got %q %q %q %q", got.Query, tc.queryType, tc.level, tc.converted, tc.errMsg,
+				got.QueryType, got.MatchLevel, got.ConvertedQuery, got.ErrMsg)
+		}
+	}
+
+	// Every match carries its xrefs, whatever the query type
+	want := map[string][]string{model.XrefCAS: {"7732-18-5"}, model.XrefChEBI: {"CHEBI:15377"}}
+	if diff := cmp.Diff(want, results[0].Matches[0].Xrefs); diff != "" {
+		t.Errorf("water xrefs mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string][]string{model.XrefKEGG: {"C01438"}}, results[5].Matches[0].Xrefs); diff != "" {
+		t.Errorf("methane xrefs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	index := privateIndex(t)
+	if _, err := index.DB().Exec(model.InsertXrefSQL, "1", model.XrefCAS, "7732-18-5"); err != nil {
+		t.Fatalf("failed to insert xref: %v", err)
+	}
+
+	doConvert := func(url, payload string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+		w := httptest.NewRecorder()
+		Convert(index, w, req)
+		return w.Result()
+	}
+
+	t.Run("json", func(t *testing.T) {
+		res := doConvert("/convert?from=inchikey&to=cas,smiles,cas", `{"queries":"MYFAKEINCHIKEY-ISRIGHTHER-E MYFAKEINCHIKEY-ANOTHERONE-E 7732-18-5 AAAAAAAAAAAAAA-BBBBBBBBBB-C"}`)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.StatusCode)
+		}
+		var rows []*ConvertResult
+		if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+
+		want := []*ConvertResult{
+			{Query: "MYFAKEINCHIKEY-ISRIGHTHER-E", MatchFound: true, MatchLevel: "Exact InChIKey", Values: map[string]string{"cas": "7732-18-5", "smiles": "O"}},
+			{Query: "MYFAKEINCHIKEY-ANOTHERONE-E", MatchFound: true, MatchLevel: "Exact InChIKey", Values: map[string]string{"cas": "", "smiles": "C"}},
+			{Query: "7732-18-5", ErrMsg: "Query is not a valid inchikey, see documentation", Values: map[string]string{"cas": "", "smiles": ""}},
+			{Query: "AAAAAAAAAAAAAA-BBBBBBBBBB-C", ErrMsg: "No compound found", Values: map[string]string{"cas": "", "smiles": ""}},
+		}
+		if diff := cmp.Diff(want, rows); diff != "" {
+			t.Errorf("convert mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		res := doConvert("/convert?to=inchikey,pubchem_cid&format=csv", `{"queries":"7732-18-5 C"}`)
+		body, _ := io.ReadAll(res.Body)
+		want := "query,found_match,match_level,error_message,inchikey,pubchem_cid\n" +
+			"7732-18-5,true,Exact CAS,,MYFAKEINCHIKEY-ISRIGHTHER-E,1\n" +
+			"C,true,Exact SMILES,,MYFAKEINCHIKEY-ANOTHERONE-E,2\n"
+		if string(body) != want {
+			t.Errorf("expected CSV:\n%s\ngot:\n%s", want, body)
+		}
+	})
+
+	t.Run("free-form source", func(t *testing.T) {
+		res := doConvert("/convert?from=compound_name&to=pubchem_cid&split=newline", `{"queries":"Methane"}`)
+		var rows []*ConvertResult
+		if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(rows) != 1 || rows[0].Values["pubchem_cid"] != "2" {
+			t.Errorf("expected Methane to convert to CID 2, got %+v", rows)
+		}
+	})
+
+	for _, url := range []string{"/convert?from=inchikey", "/convert?to=color", "/convert?from=color&to=smiles", "/convert?to=smiles&rank=color"} {
+		t.Run(url, func(t *testing.T) {
+			if res := doConvert(url, `{"queries":"O"}`); res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestMatchWithMemoryIndex(t *testing.T) {
+	memory, err := model.LoadMemoryIndex("../dataset/test_datasets/unittest_data.csv")
+	if err != nil {
+		t.Fatalf("failed to load memory index: %v", err)
+	}
+
+	// The API answers the same whichever index backs it
+	payload := `{"queries":"1 MYFAKEINCHIKEY-NOTNOTNOTN-O InChI=1S/CH4/h1H4 C=O H2O mass:99.5 formula:C1H2-4 name:methane Unknown"}`
+	url := "/match?top_hit_only=false&da=1"
+	want := parseMatchResults(t, doMatchURL(t, privateIndex(t), url, payload))
+
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+	w := httptest.NewRecorder()
+	Match(memory, w, req)
+	got := parseMatchResults(t, w.Result())
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("memory index mismatch (-sqlite +memory):\n%s", diff)
+	}
+}
+
+func TestMatchMetadata(t *testing.T) {
+	index := privateIndex(t)
+	metadata := index.Metadata()
+
+	t.Run("version", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Version(index, w, httptest.NewRequest(http.MethodGet, "/version", nil))
+		var got model.Metadata
+		if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if diff := cmp.Diff(metadata, &got); diff != "" {
+			t.Errorf("version mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		res := doMatchURL(t, index, "/match?metadata=true", `{"queries":"O"}`)
+		var got MatchResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if diff := cmp.Diff(metadata, got.Metadata); diff != "" {
+			t.Errorf("metadata mismatch (-want +got):\n%s", diff)
+		}
+		if len(got.Results) != 1 || !got.Results[0].MatchFound {
+			t.Errorf("expected a match for O, got %+v", got.Results)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		body, _ := io.ReadAll(doMatchURL(t, index, "/match?metadata=true&format=csv", `{"queries":"O"}`).Body)
+		want := fmt.Sprintf("# dataset: unittest_data\n# built_at: %s\n# source_sha256: %s\n# row_count: 3\n# schema_version: %d\n"+strings.Join(CSVHeader, ",")+"\n",
+			metadata.BuiltAt, metadata.SourceSHA256, model.SchemaVersion)
+		if !strings.HasPrefix(string(body), want) {
+			t.Errorf("expected CSV to start with:\n%s\ngot:\n%s", want, body)
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		if results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"O"}`)); len(results) != 1 {
+			t.Errorf("expected 1 result, got %d", len(results))
+		}
+	})
+}
+
+// cancellingIndex cancels its context once it has answered a SMILES lookup, like a client
+// disconnecting mid-request
+type cancellingIndex struct {
+	model.CompoundIndex
+	cancel context.CancelFunc
+}
+
+func (idx cancellingIndex) QueryBySmiles(ctx context.Context, smiles string, opts model.QueryOptions) ([]*model.Compound, int, error) {
+	defer idx.cancel()
+	return idx.CompoundIndex.QueryBySmiles(ctx, smiles, opts)
+}
+
+func TestMatchAborted(t *testing.T) {
+	index := privateIndex(t)
+
+	t.Run("stops between queries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		results := newResults([]string{"C=O", "C#C", "O=O", "1"}, model.RankByScore)
+		settings := matchSettings{opts: model.QueryOptions{TopHitOnly: true}}
+
+		err := matchResults(ctx, cancellingIndex{index, cancel}, results, settings)
+		var aborted *matchAbortedError
+		if !errors.As(err, &aborted) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected a canceled matchAbortedError, got %v", err)
+		}
+		if aborted.completed != 1 {
+			t.Errorf("expected 1 completed query, got %d", aborted.completed)
+		}
+		if results[1].MatchLevel != "" || results[3].MatchFound {
+			t.Errorf("expected the queries after the abort to be left unmatched, got %+v %+v", results[1], results[3])
+		}
+	})
+
+	t.Run("client disconnected", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/match", strings.NewReader(`{"queries":"O"}`))
+		w := httptest.NewRecorder()
+		Match(index, w, req)
+		if w.Body.Len() != 0 {
+			t.Errorf("expected no response to a disconnected client, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		defer func(timeout time.Duration) { MatchTimeout = timeout }(MatchTimeout)
+		MatchTimeout = time.Nanosecond
+
+		res := doMatchURL(t, index, "/match", `{"queries":"O C"}`)
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", res.StatusCode)
+		}
+		if want := "Request timed out after matching 0 of 2 queries"; !strings.HasPrefix(string(body), want) {
+			t.Errorf("expected body starting with %q, got %q", want, body)
+		}
+	})
+}
+
+func TestMatchFields(t *testing.T) {
+	index := privateIndex(t)
+
+	t.Run("json", func(t *testing.T) {
+		res := doMatchURL(t, index, "/match?fields=identifier,inchikey", `{"queries":"O"}`)
+		var got []struct {
+			Query   string           `json:"query"`
+			Matches []map[string]any `json:"matches"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		want := []map[string]any{{"identifier": "1", "inchikey": "MYFAKEINCHIKEY-ISRIGHTHER-E"}}
+		if len(got) != 1 || got[0].Query != "O" {
+			t.Fatalf("expected the result of O, got %+v", got)
+		}
+		if diff := cmp.Diff(want, got[0].Matches); diff != "" {
+			t.Errorf("projected matches mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		res := doMatchURL(t, index, "/match?fields=inchikey,literature_count&format=csv", `{"queries":"O C=C"}`)
+		records, err := csv.NewReader(res.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to read CSV response: %v", err)
+		}
+		want := [][]string{
+			ProjectedCSVHeader(model.FieldInChIKey | model.FieldLiteratureCount),
+			{"O", "smiles", "", "true", "Exact SMILES", "", "MYFAKEINCHIKEY-ISRIGHTHER-E", "10"},
+			{"C=C", "smiles", "", "false", "", "No compound found", "", ""},
+		}
+		if diff := cmp.Diff(want, records); diff != "" {
+			t.Errorf("projected CSV mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"query", "query_type", "converted_query", "found_match", "match_level", "error_message", "inchikey", "literature_count"}, records[0]); diff != "" {
+			t.Errorf("projected header mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		res := doMatchURL(t, index, "/match?fields=identifier,inchi_key", `{"queries":"O"}`)
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", res.StatusCode)
+		}
+		if !strings.Contains(string(body), `unknown field "inchi_key"`) {
+			t.Errorf("expected the unknown field in the error, got %q", body)
+		}
+	})
+
+	t.Run("all fields by default", func(t *testing.T) {
+		if diff := cmp.Diff(CSVHeader, ProjectedCSVHeader(0)); diff != "" {
+			t.Errorf("unprojected header mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestMixtureComponents(t *testing.T) {
+	index := privateIndex(t)
+	mockSmilesConverter(t, func(string) (string, error) { return "", nil })
+
+	t.Run("per component", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"O.C.O [Xe].[Kr]"}`))
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		mixture := results[0]
+		if !mixture.MatchFound || mixture.MatchLevel != "Mixture Components" || mixture.Matches != nil {
+			t.Fatalf("expected a mixture match without matches of its own, got %+v", mixture)
+		}
+		type component struct {
+			Query      string
+			MatchFound bool
+			Identifier string
+		}
+		var got []component
+		for _, c := range mixture.Components {
+			id := ""
+			if len(c.Matches) > 0 {
+				id = c.Matches[0].Identifier
+			}
+			got = append(got, component{c.Query, c.MatchFound, id})
+		}
+		want := []component{{"O", true, "1"}, {"C", true, "2"}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("components mismatch (-want +got):\n%s", diff)
+		}
+
+		if results[1].MatchFound || results[1].Components != nil {
+			t.Errorf("expected no match without components when no fragment matches, got %+v", results[1])
+		}
+	})
+
+	t.Run("largest fragment", func(t *testing.T) {
+		results := parseMatchResults(t, doMatchURL(t, index, "/match?largest_fragment=true", `{"queries":"[Na+].[Cl-].C=O"}`))
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		result := results[0]
+		if !result.MatchFound || result.MatchLevel != "Largest Fragment" || result.ConvertedQuery != "C=O" {
+			t.Fatalf("expected a Largest Fragment match on C=O, got %+v", result)
+		}
+		if len(result.Matches) != 1 || result.Matches[0].Identifier != "3" || result.Components != nil {
+			t.Errorf("expected formaldehyde without components, got %+v", result)
+		}
+	})
+
+	t.Run("not after an internal error", func(t *testing.T) {
+		mockSmilesConverter(t, func(string) (string, error) { return "MYFAKEINCHIKEY-ISRIGHTHER-E", nil })
+		results := newResults([]string{"O.C"}, model.RankByScore)
+		settings := matchSettings{allowRdkitConversion: true}
+		if err := matchResults(context.Background(), failingInChIKeyIndex{index}, results, settings); err != nil {
+			t.Fatalf("matchResults failed: %v", err)
+		}
+		if results[0].MatchFound || results[0].ErrMsg != "Internal server error" || results[0].Components != nil {
+			t.Errorf("expected the internal error without components, got %+v", results[0])
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		res := doMatchURL(t, index, "/match?format=csv", `{"queries":"O.[Xe]"}`)
+		records, err := csv.NewReader(res.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("failed to parse CSV: %v", err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("expected a header and a row per component, got %d rows", len(records))
+		}
+		// query, query_type, converted_query, found_match, match_level, then the pubchem_cid
+		for i, want := range [][]string{
+			{"O.[Xe]", "smiles", "O", "true", "Exact SMILES", "", "1"},
+			{"O.[Xe]", "smiles", "[Xe]", "false", "", "No compound found", ""},
+		} {
+			if diff := cmp.Diff(want, records[i+1][:7]); diff != "" {
+				t.Errorf("row %d mismatch (-want +got):\n%s", i+1, diff)
+			}
+		}
+	})
+}
+
+// failingInChIKeyIndex fails its InChIKey lookups, like a database gone away mid-request
+type failingInChIKeyIndex struct {
+	model.CompoundIndex
+}
+
+func (failingInChIKeyIndex) QueryByInChIKey(ctx context.Context, key string, opts model.QueryOptions) ([]*model.Compound, int, error) {
+	return nil, 0, errors.New("database is closed")
+}
+
+func TestLargestFragment(t *testing.T) {
+	tests := []struct {
+		fragments []string
+		want      string
+	}{
+		{[]string{"[Na+]", "CC(=O)[O-]"}, "CC(=O)[O-]"},
+		{[]string{"Cl", "CN1C=NC2=C1C(=O)N(C(=O)N2C)C"}, "CN1C=NC2=C1C(=O)N(C(=O)N2C)C"},
+		// Inorganic fragments lose to organic ones, however large
+		{[]string{"OS(=O)(=O)O", "C"}, "C"},
+		{[]string{"[Cl-]", "[Ca+2]", "[Cl-]"}, "[Cl-]"},
+		{[]string{"c1ccccc1", "[13CH3][13CH2]O"}, "c1ccccc1"},
+		{[]string{"O", "[2H]O[2H]"}, "O"},
+	}
+	for _, tt := range tests {
+		if got := largestFragment(tt.fragments); got != tt.want {
+			t.Errorf("largestFragment(%q) = %q, want %q", tt.fragments, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFormulaRange(t *testing.T) {
+	index := privateIndex(t)
+
+	// By whitespace, the element counts stay with their range and the next queries don't
+	results := parseMatchResults(t, doMatchURL(t, index, "/match", `{"queries":"formula:C1 H2-4 O0 N0 S0 CH4 O"}`))
+	var queries []string
+	for _, r := range results {
+		queries = append(queries, r.Query)
+	}
+	if diff := cmp.Diff([]string{"formula:C1 H2-4 O0 N0 S0", "CH4", "O"}, queries); diff != "" {
+		t.Fatalf("queries mismatch (-want +got):\n%s", diff)
+	}
+	if !results[0].MatchFound || results[0].QueryType != "formula_range" || len(results[0].Matches) != 1 || results[0].Matches[0].Identifier != "2" {
+		t.Errorf("expected the range to match methane only, got %+v", results[0])
+	}
+}
+
+func TestSplitByNewline(t *testing.T) {
+	index := privateIndex(t)
+
+	// By line, "name:Methane gas" stays a single query
+	results := parseMatchResults(t, doMatchURL(t, index, "/match?split=newline", `{"queries":"name:Methane gas\n  O \n\nCH4"}`))
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Query != "name:Methane gas" {
+		t.Errorf("expected the name query to keep its space, got %q", results[0].Query)
+	}
+	if !results[1].MatchFound || !results[2].MatchFound {
+		t.Error("expected the SMILES and formula lines to match")
+	}
+
+	res := doMatchURL(t, index, "/match?split=comma", `{"queries":"O"}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid split mode, got %d", res.StatusCode)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	index := privateIndex(t)
+
+	doSuggest := func(query string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/suggest?"+query, nil)
+		w := httptest.NewRecorder()
+		Suggest(index, w, req)
+		return w.Result()
+	}
+
+	t.Run("name prefix", func(t *testing.T) {
+		res := doSuggest("prefix=formal")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.StatusCode)
+		}
+		var suggestions []*model.Suggestion
+		if err := json.NewDecoder(res.Body).Decode(&suggestions); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(suggestions) != 1 || suggestions[0].CompoundName != "Formaldehyde" {
+			t.Errorf("expected the Formaldehyde suggestion, got %+v", suggestions)
+		}
+	})
+
+	t.Run("no suggestions is an empty list", func(t *testing.T) {
+		body, _ := io.ReadAll(doSuggest("prefix=zzzz").Body)
+		if strings.TrimSpace(string(body)) != "[]" {
+			t.Errorf("expected [], got %s", body)
+		}
+	})
+
+	for _, query := range []string{"prefix=me", "prefix=meth&limit=0", "prefix=meth&limit=abc"} {
+		t.Run(query, func(t *testing.T) {
+			if res := doSuggest(query); res.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", res.StatusCode)
+			}
+		})
+	}
+}
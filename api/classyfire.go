@@ -354,7 +354,7 @@ func classifiableKeys(results []*model.SingleResult) []string {
 	seen := make(map[string]bool)
 	var keys []string
 	for _, r := range results {
-		for i, c := range r.Matches {
+		for i, c := range allMatches(r) {
 			if i >= cfbMaxMatchesPerQuery {
 				c.ClassyFire = &model.ClassyFireInfo{Error: cfbCappedNote}
 				continue
@@ -524,7 +524,7 @@ func enrichWithClassyFire(ctx context.Context, results []*model.SingleResult) {
 	})
 
 	for _, r := range results {
-		for _, c := range r.Matches {
+		for _, c := range allMatches(r) {
 			if c.ClassyFire != nil {
 				continue // already capped by classifiableKeys
 			}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"ctslite/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// convertTarget is a column /convert can output, value reads it from the top hit
+type convertTarget struct {
+	name  string
+	value func(c *model.Compound) string
+}
+
+// convertTargets are the columns /convert can output, in documentation order
+var convertTargets = []convertTarget{
+	{"pubchem_cid", func(c *model.Compound) string { return c.Identifier }},
+	{"inchikey", func(c *model.Compound) string { return c.InChIKey }},
+	{"inchi", func(c *model.Compound) string { return c.InChI }},
+	{"smiles", func(c *model.Compound) string { return c.Smiles }},
+	{"compound_name", func(c *model.Compound) string { return c.CompoundName }},
+	{"molecular_formula", func(c *model.Compound) string { return c.MolecularFormula }},
+	{"exact_mass", func(c *model.Compound) string { return strconv.FormatFloat(c.ExactMass, 'f', -1, 64) }},
+	{model.XrefCAS, xrefValue(model.XrefCAS)},
+	{model.XrefHMDB, xrefValue(model.XrefHMDB)},
+	{model.XrefKEGG, xrefValue(model.XrefKEGG)},
+	{model.XrefChEBI, xrefValue(model.XrefChEBI)},
+}
+
+// xrefValue joins the cross-references of a source, a compound can have several
+func xrefValue(source string) func(c *model.Compound) string {
+	return func(c *model.Compound) string { return strings.Join(c.Xrefs[source], ";") }
+}
+
+// convertSources maps the from parameter to the query type its inputs are matched as
+var convertSources = map[string]string{
+	"auto":              "",
+	"pubchem_cid":       "pubchem_id",
+	"inchikey":          "inchikey",
+	"inchi":             "inchi",
+	"smiles":            "smiles",
+	"compound_name":     "name",
+	"molecular_formula": "formula",
+	model.XrefCAS:       "cas",
+	model.XrefHMDB:      "hmdb",
+	model.XrefKEGG:      "kegg",
+	model.XrefChEBI:     "chebi",
+}
+
+// Free-form sources are matched as given, whatever they look like
+var forcedSources = []string{"smiles", "name", "formula"}
+
+// ConvertResult is a row of /convert: the requested values of the top hit of a query
+type ConvertResult struct {
+	Query      string            `json:"query"`
+	MatchFound bool              `json:"found_match"`
+	MatchLevel string            `json:"match_level"`
+	ErrMsg     string            `json:"error_message"`
+	Values     map[string]string `json:"values"`
+}
+
+// parseConvertTargets reads the comma-separated to parameter, keeping the first of duplicates
+func parseConvertTargets(to string) ([]string, error) {
+	if to == "" {
+		return nil, errors.New("Missing to, see documentation")
+	}
+	var targets []string
+	for _, t := range strings.Split(to, ",") {
+		t = strings.TrimSpace(t)
+		if !slices.ContainsFunc(convertTargets, func(c convertTarget) bool { return c.name == t }) {
+			return nil, fmt.Errorf("Invalid to %q, see documentation", t)
+		}
+		if !slices.Contains(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
+
+// Convert translates identifiers, e.g. /convert?from=inchikey&to=cas,hmdb,smiles. It takes
+// the queries and matcher parameters of /match, and writes one row per query with the
+// values of its top hit
+func Convert(index model.CompoundIndex, w http.ResponseWriter, r *http.Request) {
+	rawQuery, ok := readRawQuery(w, r)
+	if !ok {
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = "auto"
+	}
+	queryType, ok := convertSources[from]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid from %q, see documentation", from), http.StatusBadRequest)
+		return
+	}
+	targets, err := parseConvertTargets(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := parseMatchSettings(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings.opts = model.QueryOptions{TopHitOnly: true, Rank: settings.opts.Rank}
+
+	queries, ok := splitRequestQueries(w, r, rawQuery)
+	if !ok {
+		return
+	}
+
+	// Queries that aren't of the from type are reported without being matched
+	results := newResults(queries, settings.opts.Rank)
+	var toMatch []*model.SingleResult
+	for _, result := range results {
+		switch {
+		case queryType == "", result.QueryType == queryType, result.QueryType == "bad_"+queryType:
+		case slices.Contains(forcedSources, queryType):
+			result.QueryType = queryType
+		default:
+			result.ErrMsg = fmt.Sprintf("Query is not a valid %s, see documentation", from)
+			continue
+		}
+		toMatch = append(toMatch, result)
+	}
+
+	ctx, cancel := matchContext(r)
+	defer cancel()
+
+	start := time.Now()
+	if err := matchResults(ctx, index, toMatch, settings); err != nil {
+		writeMatchError(w, r, err, len(toMatch), start)
+		return
+	}
+
+	rows := make([]*ConvertResult, len(results))
+	for i, result := range results {
+		row := &ConvertResult{
+			Query:      result.Query,
+			MatchFound: result.MatchFound,
+			MatchLevel: result.MatchLevel,
+			ErrMsg:     result.ErrMsg,
+			Values:     make(map[string]string, len(targets)),
+		}
+		for _, target := range convertTargets {
+			if !slices.Contains(targets, target.name) {
+				continue
+			}
+			row.Values[target.name] = ""
+			if result.MatchFound && len(result.Matches) > 0 {
+				row.Values[target.name] = target.value(result.Matches[0])
+			}
+		}
+		rows[i] = row
+	}
+
+	if (r.Header.Get("Accept") == "text/csv") || (r.URL.Query().Get("format") == "csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		if err := writeConvertCSV(w, rows, targets); err != nil {
+			log.Printf("Failed to write CSV response: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(rows)
+	if err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, syscall.ECONNRESET) {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeConvertCSV writes the rows with a column per target, in the order requested
+func writeConvertCSV(w http.ResponseWriter, rows []*ConvertResult, targets []string) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := append([]string{"query", "found_match", "match_level", "error_message"}, targets...)
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, row := range rows {
+		record := []string{row.Query, strconv.FormatBool(row.MatchFound), row.MatchLevel, row.ErrMsg}
+		for _, target := range targets {
+			record = append(record, row.Values[target])
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"ctslite/model"
+	"slices"
+)
+
+// resultColumns are the columns of CSVHeader describing the query, the compound columns
+// follow in the order of model.Fields
+const resultColumns = 6
+
+// ProjectedCSVHeader is CSVHeader with only the compound columns of fields, the zero
+// Fields keeps them all
+func ProjectedCSVHeader(fields model.Fields) []string {
+	return projectColumns(CSVHeader, fields)
+}
+
+// projectColumns keeps the result columns of a CSVHeader-ordered row, and its compound
+// columns in fields
+func projectColumns(row []string, fields model.Fields) []string {
+	if fields == 0 {
+		return row
+	}
+	// Clip forces append to copy rather than mutate the row, which may be CSVHeader
+	projected := slices.Clip(row[:resultColumns])
+	for i, column := range row[resultColumns:] {
+		if fields.Has(1 << i) {
+			projected = append(projected, column)
+		}
+	}
+	return projected
+}
+
+// projectedCompound is the JSON of a Compound with only the requested fields. Unlike
+// Compound, every field is omitted when not requested, annotations are kept as they are
+type projectedCompound struct {
+	Identifier       *string  `json:"identifier,omitempty"`
+	InChIKey         *string  `json:"inchikey,omitempty"`
+	InChI            *string  `json:"inchi,omitempty"`
+	Smiles           *string  `json:"smiles,omitempty"`
+	CompoundName     *string  `json:"compound_name,omitempty"`
+	MolecularFormula *string  `json:"molecular_formula,omitempty"`
+	ExactMass        *float64 `json:"exact_mass,omitempty"`
+	LiteratureCount  *float32 `json:"literature_count,omitempty"`
+	PatentCount      *float32 `json:"patent_count,omitempty"`
+	model.Properties
+	Source     string                `json:"source,omitempty"`
+	Synonym    string                `json:"synonym,omitempty"`
+	Xrefs      map[string][]string   `json:"xrefs,omitempty"`
+	Adduct     string                `json:"adduct,omitempty"`
+	MassError  *float64              `json:"mass_error_ppm,omitempty"`
+	ClassyFire *model.ClassyFireInfo `json:"classyfire,omitempty"`
+}
+
+// pick returns v if fields has field, nil otherwise
+func pick[T any](fields, field model.Fields, v T) *T {
+	if !fields.Has(field) {
+		return nil
+	}
+	return &v
+}
+
+// pickKnown returns the property v if fields has field, nil otherwise
+func pickKnown[T any](fields, field model.Fields, v *T) *T {
+	if !fields.Has(field) {
+		return nil
+	}
+	return v
+}
+
+func projectCompound(c *model.Compound, fields model.Fields) *projectedCompound {
+	projected := &projectedCompound{
+		Identifier:       pick(fields, model.FieldIdentifier, c.Identifier),
+		InChIKey:         pick(fields, model.FieldInChIKey, c.InChIKey),
+		InChI:            pick(fields, model.FieldInChI, c.InChI),
+		Smiles:           pick(fields, model.FieldSmiles, c.Smiles),
+		CompoundName:     pick(fields, model.FieldCompoundName, c.CompoundName),
+		MolecularFormula: pick(fields, model.FieldMolecularFormula, c.MolecularFormula),
+		ExactMass:        pick(fields, model.FieldExactMass, c.ExactMass),
+		LiteratureCount:  pick(fields, model.FieldLiteratureCount, c.LiteratureCount),
+		PatentCount:      pick(fields, model.FieldPatentCount, c.PatentCount),
+		Properties: model.Properties{
+			Charge:             pickKnown(fields, model.FieldCharge, c.Charge),
+			MolecularWeight:    pickKnown(fields, model.FieldMolecularWeight, c.MolecularWeight),
+			XLogP:              pickKnown(fields, model.FieldXLogP, c.XLogP),
+			TPSA:               pickKnown(fields, model.FieldTPSA, c.TPSA),
+			HBondDonorCount:    pickKnown(fields, model.FieldHBondDonorCount, c.HBondDonorCount),
+			HBondAcceptorCount: pickKnown(fields, model.FieldHBondAcceptorCount, c.HBondAcceptorCount),
+			HeavyAtomCount:     pickKnown(fields, model.FieldHeavyAtomCount, c.HeavyAtomCount),
+		},
+		Synonym:    c.Synonym,
+		Xrefs:      c.Xrefs,
+		Adduct:     c.Adduct,
+		MassError:  c.MassError,
+		ClassyFire: c.ClassyFire,
+	}
+	if fields.Has(model.FieldSource) {
+		projected.Source = c.Source
+	}
+	return projected
+}
+
+// projectedResult is the JSON of a SingleResult with projected matches, its Matches and
+// Components shadow those of the SingleResult
+type projectedResult struct {
+	*model.SingleResult
+	Matches    []*projectedCompound `json:"matches"`
+	Components []*projectedResult   `json:"components,omitempty"`
+}
+
+func projectResult(result *model.SingleResult, fields model.Fields) *projectedResult {
+	projected := &projectedResult{SingleResult: result}
+	if result.Matches != nil {
+		projected.Matches = make([]*projectedCompound, len(result.Matches))
+		for i, c := range result.Matches {
+			projected.Matches[i] = projectCompound(c, fields)
+		}
+	}
+	for _, component := range result.Components {
+		projected.Components = append(projected.Components, projectResult(component, fields))
+	}
+	return projected
+}
+
+// projectResults returns the results with only the compound fields of fields for JSON
+// encoding, or the results themselves for the zero Fields
+func projectResults(results []*model.SingleResult, fields model.Fields) any {
+	if fields == 0 {
+		return results
+	}
+	projected := make([]*projectedResult, len(results))
+	for i, result := range results {
+		projected[i] = projectResult(result, fields)
+	}
+	return projected
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"context"
 	"ctslite/model"
 	"ctslite/telemetry"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"regexp"
@@ -15,15 +17,23 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var inchikeyPattern = regexp.MustCompile(`^[A-Z]{14}-[A-Z]{10}-[A-Z]$`)
 var badInchikeyPattern = regexp.MustCompile(`^[a-zA-Z]{12,16}-[a-zA-Z]{9,11}-[a-zA-Z]{0,2}$`)
 
+// elementRangePattern matches a spaced element count of a formula range, such as "H10-14" or "N0"
+var elementRangePattern = regexp.MustCompile(`^[A-Z][a-z]?\d+(-\d+)?$`)
+
 var CSVHeader = []string{
 	"query", "query_type", "converted_query", "found_match", "match_level", "error_message",
 	"pubchem_cid", "inchikey", "inchi", "smiles", "compound_name",
 	"molecular_formula", "exact_mass", "literature_count", "patent_count",
+	"charge", "molecular_weight", "xlogp", "tpsa",
+	"hbond_donor_count", "hbond_acceptor_count", "heavy_atom_count",
+	"source",
 }
 
 func isAllDigits(s string) bool {
@@ -35,9 +45,138 @@ func isAllDigits(s string) bool {
 	return len(s) > 0
 }
 
+// xrefQueryType returns the source of a cross-reference query ("cas", "hmdb", "kegg" or
+// "chebi"), "bad_cas" for a CAS number with a wrong check digit, or ""
+func xrefQueryType(s string) string {
+	source, _, err := model.ParseXref(s)
+	if errors.Is(err, model.ErrCASChecksum) {
+		return "bad_cas"
+	}
+	return source
+}
+
+func isFormula(s string) bool {
+	_, err := model.ParseFormula(s)
+	return err == nil
+}
+
+// isAromaticSmiles reports whether s reads as a SMILES of aromatic rings such as
+// "c1ccccc1" or "nc1ccccc1": organic atoms and ring closures only, each closure paired
+func isAromaticSmiles(s string) bool {
+	s = strings.NewReplacer("Cl", "", "Br", "").Replace(s)
+	rings := make(map[rune]int)
+	aromatic := false
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune("bcnops", r):
+			aromatic = true
+		case '0' <= r && r <= '9':
+			rings[r]++
+		case !strings.ContainsRune("BCNOPSFI", r):
+			return false
+		}
+	}
+	if !aromatic || len(rings) == 0 {
+		return false
+	}
+	for _, n := range rings {
+		if n%2 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// couldBeSmiles reports whether a query of letters and digits could be a SMILES of the
+// organic subset rather than only a formula: no hydrogen or other elements outside
+// brackets, as in "OH2" or "NaCl", and each ring closure paired, unlike the count of "C2O"
+func couldBeSmiles(s string) bool {
+	s = strings.NewReplacer("Cl", "", "Br", "").Replace(s)
+	rings := make(map[rune]int)
+	for _, r := range s {
+		switch {
+		case '0' <= r && r <= '9':
+			rings[r]++
+		case !strings.ContainsRune("BCNOPSFIbcnops", r):
+			return false
+		}
+	}
+	for _, n := range rings {
+		if n%2 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isFormulaRange(q string) bool {
+	return len(q) >= 8 && strings.EqualFold(q[:8], "formula:")
+}
+
+func startsWithLetter(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r)
+}
+
+// splitQueries splits the raw query by whitespace (default), or by line so that
+// queries such as compound names can contain spaces. By whitespace, the element counts
+// following a formula range stay part of it: "formula:C6-8 H10-14 N0" is one query
+func splitQueries(rawQuery, mode string) ([]string, error) {
+	switch mode {
+	case "", "whitespace":
+		var queries []string
+		for _, field := range strings.Fields(rawQuery) {
+			if n := len(queries); n > 0 && elementRangePattern.MatchString(field) && isFormulaRange(queries[n-1]) {
+				queries[n-1] += " " + field
+				continue
+			}
+			queries = append(queries, field)
+		}
+		return queries, nil
+	case "newline":
+		var queries []string
+		for _, line := range strings.Split(rawQuery, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				queries = append(queries, line)
+			}
+		}
+		return queries, nil
+	default:
+		return nil, fmt.Errorf("Invalid split mode %q, must be whitespace or newline", mode)
+	}
+}
+
+// parseCount reads an optional non-negative integer request parameter, 0 if absent
+func parseCount(value, name string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s, must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
 func parseQueryType(q string) string {
 	// Order of cases matters here
 	switch {
+	case len(q) >= 5 && strings.EqualFold(q[:5], "mass:"):
+		// log.Println("Query identified as exact mass")
+		return "mass"
+
+	case len(q) >= 3 && strings.EqualFold(q[:3], "mz:"):
+		// log.Println("Query identified as m/z")
+		return "mz"
+
+	case isFormulaRange(q):
+		// log.Println("Query identified as formula range")
+		return "formula_range"
+
+	case len(q) >= 5 && strings.EqualFold(q[:5], "name:"):
+		// log.Println("Query identified as compound name")
+		return "name"
+
 	case inchikeyPattern.MatchString(q):
 		// log.Println("Query identified as InChIKey")
 		return "inchikey"
@@ -54,6 +193,15 @@ func parseQueryType(q string) string {
 		// log.Println("Query identified as malformed InChI")
 		return "bad_inchi"
 
+	case xrefQueryType(q) != "":
+		// log.Println("Query identified as cross-reference")
+		return xrefQueryType(q)
+
+	// Charged or spaced formulas such as "C2H3O2-" or "C6 H12 O6" would otherwise pass for SMILES
+	case strings.ContainsAny(q, "+- ") && isFormula(q):
+		// log.Println("Query identified as Molecular Formula")
+		return "formula"
+
 	// See if first char matches any first char of SMILES in the db
 	case strings.ContainsAny(q, "=#/\\:.@+-[]()"):
 		// log.Println("Query identified as SMILES")
@@ -71,19 +219,71 @@ func parseQueryType(q string) string {
 		// log.Println("Query identified as PubChem ID")
 		return "pubchem_id"
 
+	// Lowercase aromatic SMILES such as "c1ccccc1" would otherwise pass for names
+	case isAromaticSmiles(q):
+		// log.Println("Query identified as SMILES")
+		return "smiles"
+
+	// Lowercase formulas such as "h2o" would otherwise pass for names
+	case isFormula(q) && !strings.ContainsFunc(q, unicode.IsUpper):
+		// log.Println("Query identified as Molecular Formula")
+		return "formula"
+
+	// Remaining queries starting with a letter (lowercase, J, Q, non-ASCII) can only be names
+	case startsWithLetter(q):
+		// log.Println("Query identified as compound name")
+		return "name"
+
 	default:
 		// log.Println("Query type could not be identified")
 		return "unidentified"
 	}
 }
 
-// writeResultsAsCSV converts the results to CSV format and writes to the response writer
-func writeResultsAsCSV(w http.ResponseWriter, results []*model.SingleResult, classyfireEnabled bool) error {
+// MatchResponse is the /match JSON response when metadata=true, otherwise only the
+// results are written
+type MatchResponse struct {
+	Metadata *model.Metadata       `json:"metadata"`
+	Results  []*model.SingleResult `json:"results"`
+}
+
+// matchResponse is the JSON body of a /match response: the results with the compound
+// fields of fields, wrapped in a MatchResponse when the metadata is requested
+func matchResponse(results []*model.SingleResult, metadata *model.Metadata, fields model.Fields) any {
+	switch {
+	case metadata == nil:
+		return projectResults(results, fields)
+	case fields == 0:
+		return MatchResponse{Metadata: metadata, Results: results}
+	}
+	return struct {
+		Metadata *model.Metadata `json:"metadata"`
+		Results  any             `json:"results"`
+	}{metadata, projectResults(results, fields)}
+}
+
+// writeMetadataComments writes the metadata as "# key: value" lines, ahead of the CSV header
+func writeMetadataComments(w io.Writer, metadata *model.Metadata) error {
+	_, err := fmt.Fprintf(w, "# dataset: %s\n# built_at: %s\n# source_sha256: %s\n# row_count: %d\n# schema_version: %d\n",
+		metadata.Dataset, metadata.BuiltAt, metadata.SourceSHA256, metadata.RowCount, metadata.SchemaVersion)
+	return err
+}
+
+// writeResultsAsCSV converts the results to CSV format and writes to the response writer,
+// preceded by the dataset metadata unless it is nil. Only the compound columns of fields
+// are written, see ProjectedCSVHeader
+func writeResultsAsCSV(w http.ResponseWriter, results []*model.SingleResult, classyfireEnabled bool, metadata *model.Metadata, fields model.Fields) error {
+	if metadata != nil {
+		if err := writeMetadataComments(w, metadata); err != nil {
+			return fmt.Errorf("failed to write CSV metadata: %w", err)
+		}
+	}
+
 	writer := csv.NewWriter(w)
 	defer writer.Flush()
 
 	// Write CSV header
-	header := CSVHeader
+	header := ProjectedCSVHeader(fields)
 	if classyfireEnabled {
 		// Clip forces append to copy rather than mutate the shared CSVHeader
 		header = append(slices.Clip(header),
@@ -103,8 +303,8 @@ func writeResultsAsCSV(w http.ResponseWriter, results []*model.SingleResult, cla
 		return []string{cf.Kingdom, cf.Superclass, cf.Class, cf.Subclass, cf.DirectParent, cf.Description, cf.Error}
 	}
 
-	// Write data rows
-	for _, result := range results {
+	// writeRows writes one row per match of result, or a single row if it has none
+	writeRows := func(result *model.SingleResult) error {
 		if !result.MatchFound {
 			// Write a single row for failed matches
 			row := []string{
@@ -114,13 +314,14 @@ func writeResultsAsCSV(w http.ResponseWriter, results []*model.SingleResult, cla
 				strconv.FormatBool(result.MatchFound),
 				result.MatchLevel,
 				result.ErrMsg,
-				"", "", "", "", "", "", "", "", "", // Empty compound fields
 			}
+			// Empty compound fields
+			row = projectColumns(append(row, make([]string, len(CSVHeader)-len(row))...), fields)
 			if classyfireEnabled {
 				row = append(row, cfFields(nil)...)
 			}
 			if err := writer.Write(row); err != nil {
-				return fmt.Errorf("failed to write CSV row: %w", err)
+				return err
 			}
 		} else {
 			// Write one row per match
@@ -142,22 +343,43 @@ func writeResultsAsCSV(w http.ResponseWriter, results []*model.SingleResult, cla
 					strconv.FormatFloat(float64(match.LiteratureCount), 'f', -1, 32),
 					strconv.FormatFloat(float64(match.PatentCount), 'f', -1, 32),
 				}
+				row = append(row, match.Properties.CSVValues()...)
+				row = projectColumns(append(row, match.Source), fields)
 				if classyfireEnabled {
 					row = append(row, cfFields(match.ClassyFire)...)
 				}
 				if err := writer.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// Write data rows
+	for _, result := range results {
+		// Mixtures get the rows of each component, with the fragment as converted query
+		if len(result.Components) > 0 {
+			for _, component := range result.Components {
+				row := *component
+				row.Query, row.QueryType, row.ConvertedQuery = result.Query, result.QueryType, component.Query
+				if err := writeRows(&row); err != nil {
 					return fmt.Errorf("failed to write CSV row: %w", err)
 				}
 			}
+			continue
+		}
+		if err := writeRows(result); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
 		}
 	}
 
 	return nil
 }
 
-// Match is the main entry point for the API
-// Detects the type of query and delegates it to the corresponding matching function
-func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
+// readRawQuery reads the queries of a GET (q) or POST ({"queries": ...}) request, it
+// writes the error response and returns false if there are none
+func readRawQuery(w http.ResponseWriter, r *http.Request) (string, bool) {
 	var rawQuery string
 
 	// Parse query according to GET or POST request (GET was the old method before moving to POST)
@@ -173,46 +395,95 @@ func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
 
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
+			return "", false
 		}
 
 		rawQuery = strings.TrimSpace(request.Queries)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		return "", false
 	}
 
 	if rawQuery == "" {
 		http.Error(w, "Query was empty", http.StatusBadRequest)
-		return
+		return "", false
 	}
+	return rawQuery, true
+}
 
-	// Check for request parameters
-	var topHitOnly bool = r.URL.Query().Get("top_hit_only") != "false"
-	var allowFirstBlockMatches bool = r.URL.Query().Get("first_block_matches") != "false"
-	var classyfireEnabled bool = r.URL.Query().Get("classyfire") == "true"
-	var stream bool = r.URL.Query().Get("stream") == "true"
-	var allowRdkitConversion bool = r.URL.Query().Get("rdkit_conversion") != "false"
+// matchSettings are the request parameters that drive the matchers
+type matchSettings struct {
+	opts                    model.QueryOptions
+	allowFirstBlockMatches  bool
+	allowProtonationMatches bool
+	allowRdkitConversion    bool
+	largestFragmentOnly     bool
+	tolerance               massTolerance
+	ionAdducts              []Adduct
+	// fields are the compound fields written in the response, opts.Fields also selects
+	//   those the response needs internally
+	fields model.Fields
+}
+
+// parseMatchSettings reads the matcher parameters of a request, errors are meant for a 400
+func parseMatchSettings(r *http.Request) (matchSettings, error) {
+	params := r.URL.Query()
+	s := matchSettings{
+		allowFirstBlockMatches:  params.Get("first_block_matches") != "false",
+		allowProtonationMatches: params.Get("protonation_matches") != "false",
+		allowRdkitConversion:    params.Get("rdkit_conversion") != "false",
+		largestFragmentOnly:     params.Get("largest_fragment") == "true",
+	}
 
+	var err error
+	if s.tolerance, err = parseMassTolerance(params.Get("ppm"), params.Get("da")); err != nil {
+		return s, err
+	}
+	if s.opts.Rank, err = parseRanking(params.Get("rank")); err != nil {
+		return s, err
+	}
+	if s.opts.MaxHits, err = parseCount(params.Get("max_hits"), "max_hits"); err != nil {
+		return s, err
+	}
+	if s.opts.Offset, err = parseCount(params.Get("offset"), "offset"); err != nil {
+		return s, err
+	}
+	if s.ionAdducts, err = selectAdducts(params.Get("ion_mode"), params.Get("adducts")); err != nil {
+		return s, err
+	}
+	if s.fields, err = model.ParseFields(params.Get("fields")); err != nil {
+		return s, fmt.Errorf("Invalid fields: %w", err)
+	}
+	s.opts.Fields = s.fields
+	if s.fields != 0 && params.Get("classyfire") == "true" {
+		// ClassyFire classifies the matches by InChIKey
+		s.opts.Fields |= model.FieldInChIKey
+	}
+	s.opts.TopHitOnly = params.Get("top_hit_only") != "false"
+	return s, nil
+}
+
+// splitRequestQueries splits the raw query according to the split parameter and enforces
+// the query limit, it writes the error response and returns false on failure
+func splitRequestQueries(w http.ResponseWriter, r *http.Request, rawQuery string) ([]string, bool) {
 	// Split query by space or newline (can't use comma because InChI or SMILES can contain commas)
-	queries := strings.Fields(rawQuery)
+	queries, err := splitQueries(rawQuery, r.URL.Query().Get("split"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
 
 	if len(queries) > 100000 {
 		http.Error(w, fmt.Sprintf("Query contains %d identifiers (limit 100,000)", len(queries)), http.StatusBadRequest)
-		return
-	}
-
-	// Enforce ClassyFire query limit
-	if classyfireEnabled && len(queries) > 1000 {
-		http.Error(w, fmt.Sprintf("Query contains %d identifiers (limit 1,000 when ClassyFire is enabled)", len(queries)), http.StatusBadRequest)
-		return
+		return nil, false
 	}
+	return queries, true
+}
 
+// newResults creates a result per non-empty query, with its detected query type
+func newResults(queries []string, rank model.Ranking) []*model.SingleResult {
 	results := make([]*model.SingleResult, 0, len(queries))
-	var matchCount int = 0
-	timeStart := time.Now()
-
 	for _, q := range queries {
 		q = strings.TrimSpace(q)
 
@@ -226,29 +497,90 @@ func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		result := &model.SingleResult{
+		results = append(results, &model.SingleResult{
 			Query:     q,
 			QueryType: parseQueryType(q),
-		}
+			Rank:      rank.String(),
+		})
+	}
+	return results
+}
 
-		switch result.QueryType {
-		case "pubchem_id":
-			matchPubChemID(index, q, result, topHitOnly)
+// MatchTimeout bounds the matching of a /match or /convert request, no bound if 0. The
+// server sets it from MATCH_TIMEOUT
+var MatchTimeout time.Duration
 
-		case "inchi":
-			matchInchi(index, q, result, topHitOnly)
+// matchContext is the context matching runs under: the request's, which is done once the
+// client disconnects, bounded by MatchTimeout
+func matchContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if MatchTimeout > 0 {
+		return context.WithTimeout(r.Context(), MatchTimeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// matchAbortedError is returned by matchResults when its context is done before all the
+// queries are matched
+type matchAbortedError struct {
+	completed int
+	err       error
+}
+
+func (e *matchAbortedError) Error() string {
+	return fmt.Sprintf("matching aborted after %d queries: %v", e.completed, e.err)
+}
 
-		case "inchikey":
-			matchInchiKey(index, q, result, allowFirstBlockMatches, topHitOnly)
+func (e *matchAbortedError) Unwrap() error { return e.err }
+
+// matchResults runs the matcher of each result's query type. Identifier types are
+// resolved per type in a few set-based lookups, the rest one by one. It stops between
+// lookups once ctx is done, returning a *matchAbortedError
+func matchResults(ctx context.Context, index model.CompoundIndex, results []*model.SingleResult, s matchSettings) error {
+	opts := s.opts
+	batches := make(map[string][]*model.SingleResult)
+	completed := 0
+
+	for _, result := range results {
+		if err := ctx.Err(); err != nil {
+			return &matchAbortedError{completed, err}
+		}
+		q := result.Query
+
+		switch result.QueryType {
+		case "pubchem_id", "inchi", "inchikey":
+			batches[result.QueryType] = append(batches[result.QueryType], result)
 
 		case "smiles":
-			matchSmiles(index, q, result, allowFirstBlockMatches, topHitOnly, allowRdkitConversion)
+			matchSmiles(ctx, index, q, result, s.allowProtonationMatches, s.allowFirstBlockMatches, opts, s.allowRdkitConversion)
+			matchComponents(ctx, index, q, result, s)
+			matchNameFallback(ctx, index, q, result, opts)
 
 		case "formula":
-			matchFormula(index, q, result, topHitOnly)
+			matchFormula(ctx, index, q, result, opts, true)
+			matchNameFallback(ctx, index, q, result, opts)
 
 		case "smiles_or_formula":
-			matchSmilesOrFormula(index, q, result, allowFirstBlockMatches, topHitOnly, allowRdkitConversion)
+			matchSmilesOrFormula(ctx, index, q, result, s.allowProtonationMatches, s.allowFirstBlockMatches, opts, s.allowRdkitConversion)
+			matchNameFallback(ctx, index, q, result, opts)
+
+		case "name":
+			matchName(ctx, index, stripNamePrefix(q), result, opts)
+
+		case "mass":
+			matchMass(ctx, index, q, result, s.tolerance, opts)
+
+		case "mz":
+			matchMz(ctx, index, q, result, s.tolerance, s.ionAdducts, opts)
+
+		case "formula_range":
+			matchFormulaRange(ctx, index, q, result, opts)
+
+		case "cas", "hmdb", "kegg", "chebi":
+			matchXref(ctx, index, q, result, opts)
+
+		case "bad_cas":
+			result.MatchFound = false
+			result.ErrMsg = "Malformed CAS number, see documentation"
 
 		case "bad_inchi":
 			result.MatchFound = false
@@ -263,32 +595,128 @@ func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
 			result.ErrMsg = "Invalid query type, could not identify, see documentation"
 
 		default:
-			log.Printf("ERROR: An unexpected error occured when parsing the request. Query type unhandled. Query: '%s'", q)
-			http.Error(w, "An unexpected error occurred when parsing the request", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("query type %q unhandled, query: '%s'", result.QueryType, q)
+		}
+		if _, batched := batches[result.QueryType]; !batched {
+			completed++
 		}
+	}
+
+	batchMatchers := []struct {
+		queryType string
+		match     func(batch []*model.SingleResult)
+	}{
+		{"pubchem_id", func(batch []*model.SingleResult) { matchPubChemIDs(ctx, index, batch, opts) }},
+		{"inchi", func(batch []*model.SingleResult) { matchInchis(ctx, index, batch, opts) }},
+		{"inchikey", func(batch []*model.SingleResult) {
+			matchInchiKeys(ctx, index, batch, s.allowProtonationMatches, s.allowFirstBlockMatches, opts)
+		}},
+	}
+	for _, b := range batchMatchers {
+		batch := batches[b.queryType]
+		if len(batch) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return &matchAbortedError{completed, err}
+		}
+		b.match(batch)
+		completed += len(batch)
+	}
+
+	// Lookups that failed as ctx got done reported internal errors, the request is aborted
+	if err := ctx.Err(); err != nil {
+		return &matchAbortedError{completed, err}
+	}
+	attachXrefs(ctx, index, results)
+	return nil
+}
+
+// writeMatchError reports an error of matchResults. Aborted requests are recorded, and a
+// client that timed out is told so, one that disconnected gets nothing
+func writeMatchError(w http.ResponseWriter, r *http.Request, err error, queries int, start time.Time) {
+	var aborted *matchAbortedError
+	if !errors.As(err, &aborted) {
+		log.Printf("ERROR: An unexpected error occured when parsing the request. %v", err)
+		http.Error(w, "An unexpected error occurred when parsing the request", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Matching aborted after %d of %d queries in %s: %v", aborted.completed, queries, time.Since(start).Round(time.Millisecond), aborted.err)
+	telemetry.RecordMatchAborted(r, aborted.completed, queries, time.Since(start), aborted.err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, fmt.Sprintf("Request timed out after matching %d of %d queries, send fewer queries per request", aborted.completed, queries), http.StatusServiceUnavailable)
+	}
+}
+
+// Match is the main entry point for the API
+// Detects the type of query and delegates it to the corresponding matching function
+func Match(index model.CompoundIndex, w http.ResponseWriter, r *http.Request) {
+	rawQuery, ok := readRawQuery(w, r)
+	if !ok {
+		return
+	}
+
+	// Check for request parameters
+	var classyfireEnabled bool = r.URL.Query().Get("classyfire") == "true"
+	var stream bool = r.URL.Query().Get("stream") == "true"
+
+	// The dataset metadata is only included on request, it changes the JSON response shape
+	var metadata *model.Metadata
+	if r.URL.Query().Get("metadata") == "true" {
+		metadata = index.Metadata()
+	}
+
+	settings, err := parseMatchSettings(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	queries, ok := splitRequestQueries(w, r, rawQuery)
+	if !ok {
+		return
+	}
+
+	// Enforce ClassyFire query limit
+	if classyfireEnabled && len(queries) > 1000 {
+		http.Error(w, fmt.Sprintf("Query contains %d identifiers (limit 1,000 when ClassyFire is enabled)", len(queries)), http.StatusBadRequest)
+		return
+	}
+
+	var matchCount int = 0
+	timeStart := time.Now()
+
+	ctx, cancel := matchContext(r)
+	defer cancel()
+
+	results := newResults(queries, settings.opts.Rank)
+	if err := matchResults(ctx, index, results, settings); err != nil {
+		writeMatchError(w, r, err, len(results), timeStart)
+		return
+	}
+
+	for _, result := range results {
 		if result.MatchFound {
 			matchCount++
 		}
-
-		results = append(results, result)
 	}
 
 	duration := time.Since(timeStart)
 	log.Printf("%d matches found from %d queries in %s\n", matchCount, len(queries), time.Since(timeStart).Round(time.Millisecond))
 	telemetry.RecordMatch(r, results, matchCount, duration, telemetry.MatchOptions{
-		TopHitOnly:             topHitOnly,
-		AllowFirstBlockMatches: allowFirstBlockMatches,
-		AllowRdkitConversion:   allowRdkitConversion,
-		ClassyFireEnabled:      classyfireEnabled,
+		TopHitOnly:              settings.opts.TopHitOnly,
+		AllowFirstBlockMatches:  settings.allowFirstBlockMatches,
+		AllowProtonationMatches: settings.allowProtonationMatches,
+		AllowRdkitConversion:    settings.allowRdkitConversion,
+		ClassyFireEnabled:       classyfireEnabled,
 	})
 
 	csvRequested := (r.Header.Get("Accept") == "text/csv") || (r.URL.Query().Get("format") == "csv")
 
 	// Emit matches immediately, and classifications as they come. Not possible for CSV
 	if stream && classyfireEnabled && !csvRequested {
-		streamMatchResults(w, r, results)
+		streamMatchResults(w, r, results, metadata, settings.fields)
 		return
 	}
 
@@ -299,13 +727,13 @@ func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
 
 	if csvRequested {
 		w.Header().Set("Content-Type", "text/csv")
-		err := writeResultsAsCSV(w, results, classyfireEnabled)
+		err := writeResultsAsCSV(w, results, classyfireEnabled, metadata, settings.fields)
 		if err != nil {
 			log.Printf("Failed to write CSV response: %v", err)
 		}
 	} else {
 		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(results)
+		err := json.NewEncoder(w).Encode(matchResponse(results, metadata, settings.fields))
 		if err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, syscall.ECONNRESET) {
 			log.Printf("Failed to encode JSON response: %v", err)
 		}
@@ -314,12 +742,12 @@ func Match(index *model.PubChemIndex, w http.ResponseWriter, r *http.Request) {
 }
 
 // streamMatchResults writes the response as NDJSON
-func streamMatchResults(w http.ResponseWriter, r *http.Request, results []*model.SingleResult) {
+func streamMatchResults(w http.ResponseWriter, r *http.Request, results []*model.SingleResult, metadata *model.Metadata, fields model.Fields) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		enrichWithClassyFire(r.Context(), results)
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(results); err != nil &&
+		if err := json.NewEncoder(w).Encode(matchResponse(results, metadata, fields)); err != nil &&
 			!errors.Is(err, syscall.EPIPE) && !errors.Is(err, syscall.ECONNRESET) {
 			log.Printf("Failed to encode JSON response: %v", err)
 		}
@@ -350,7 +778,11 @@ func streamMatchResults(w http.ResponseWriter, r *http.Request, results []*model
 		defer cfbLeaveQueue()
 	}
 
-	if !writeLine(map[string]any{"type": "matches", "results": results, "unique": len(keys), "queue": cfbQueueDepth()}) {
+	matches := map[string]any{"type": "matches", "results": projectResults(results, fields), "unique": len(keys), "queue": cfbQueueDepth()}
+	if metadata != nil {
+		matches["metadata"] = metadata
+	}
+	if !writeLine(matches) {
 		return
 	}
 
@@ -361,6 +793,14 @@ func streamMatchResults(w http.ResponseWriter, r *http.Request, results []*model
 	writeLine(map[string]any{"type": "done"})
 }
 
+// Version reports the dataset the server is matching against, for reproducibility
+func Version(index model.CompoundIndex, w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(index.Metadata()); err != nil {
+		log.Printf("Failed to encode version response: %v", err)
+	}
+}
+
 func Status(w http.ResponseWriter, _ *http.Request) {
 	_, err := fmt.Fprintln(w, "The CTSLite server is up and running!")
 	if err != nil {
@@ -1,54 +1,143 @@
 package api
 
 import (
+	"context"
 	"ctslite/model"
 	"ctslite/rdkit"
+	"errors"
 	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 var smilesToInChIKey = func(smiles string) (string, error) {
 	return rdkit.SmilesToInChIKey(smiles)
 }
 
-func matchPubChemID(index *model.PubChemIndex, query string, result *model.SingleResult, topHitOnly bool) {
-	compounds, err := index.QueryByPubChemID(query, topHitOnly)
-	if err != nil {
-		log.Printf("Error querying by PubChem ID: %v", err)
+// The batch matchers below resolve every query of one type with a few set-based
+//   lookups, each result ends up as if matched on its own
+
+// batchQueries returns the queries of results, to be looked up together
+func batchQueries(results []*model.SingleResult) []string {
+	queries := make([]string, len(results))
+	for i, result := range results {
+		queries[i] = result.Query
+	}
+	return queries
+}
+
+// applyBatch sets each result from the hits and total of its key, returning the results that missed
+func applyBatch(results []*model.SingleResult, hits map[string][]*model.Compound, totals map[string]int, key func(q string) string, matchLevel string) []*model.SingleResult {
+	var misses []*model.SingleResult
+	for _, result := range results {
+		compounds := hits[key(result.Query)]
+		if len(compounds) == 0 {
+			result.MatchFound = false
+			result.ErrMsg = "No compound found"
+			misses = append(misses, result)
+			continue
+		}
+		result.MatchFound = true
+		result.MatchLevel = matchLevel
+		result.Matches = compounds
+		result.TotalHits = totals[key(result.Query)]
+	}
+	return misses
+}
+
+// failBatch marks every result of a failed batch lookup as an internal error
+func failBatch(results []*model.SingleResult) {
+	for _, result := range results {
 		result.MatchFound = false
 		result.ErrMsg = "Internal server error"
+	}
+}
+
+func sameQuery(q string) string { return q }
+
+func matchPubChemIDs(ctx context.Context, index model.CompoundIndex, results []*model.SingleResult, opts model.QueryOptions) {
+	hits, totals, err := index.QueryByPubChemIDs(ctx, batchQueries(results), opts)
+	if err != nil {
+		log.Printf("Error querying by PubChem IDs: %v", err)
+		failBatch(results)
 		return
 	}
-	if len(compounds) == 0 {
-		result.MatchFound = false
-		result.ErrMsg = "No compound found"
+	applyBatch(results, hits, totals, sameQuery, "Exact PubChem ID")
+}
+
+func matchInchis(ctx context.Context, index model.CompoundIndex, results []*model.SingleResult, opts model.QueryOptions) {
+	hits, totals, err := index.QueryByInChIs(ctx, batchQueries(results), opts)
+	if err != nil {
+		log.Printf("Error querying by InChIs: %v", err)
+		failBatch(results)
 		return
 	}
-	result.MatchFound = true
-	result.MatchLevel = "Exact PubChem ID"
-	result.Matches = compounds
+	misses := applyBatch(results, hits, totals, sameQuery, "Exact InChI")
+	if len(misses) == 0 {
+		return
+	}
+
+	// Fall back to the skeleton InChI, ignoring stereo and isotopic layers
+	hits, totals, err = index.QueryBySkeletonInChIs(ctx, batchQueries(misses), opts)
+	if err != nil {
+		log.Printf("Error querying by skeleton InChIs: %v", err)
+		failBatch(misses)
+		return
+	}
+	applyBatch(misses, hits, totals, model.SkeletonInChI, "InChI (stereo-insensitive)")
 }
 
-func matchInchi(index *model.PubChemIndex, query string, result *model.SingleResult, topHitOnly bool) {
-	compounds, err := index.QueryByInChI(query, topHitOnly)
+func matchInchiKeys(ctx context.Context, index model.CompoundIndex, results []*model.SingleResult, allowProtonationMatches bool, allowFirstBlockMatches bool, opts model.QueryOptions) {
+	hits, totals, err := index.QueryByInChIKeys(ctx, batchQueries(results), opts)
 	if err != nil {
-		log.Printf("Error querying by InChI: %v", err)
-		result.MatchFound = false
-		result.ErrMsg = "Internal server error"
+		log.Printf("Error querying by InChIKeys: %v", err)
+		failBatch(results)
 		return
 	}
-	if len(compounds) == 0 {
-		result.MatchFound = false
-		result.ErrMsg = "No compound found"
+	misses := applyBatch(results, hits, totals, sameQuery, "Exact InChIKey")
+
+	// Fall back to the first two blocks, ignoring the protonation flag
+	if allowProtonationMatches && len(misses) > 0 {
+		hits, totals, err = index.QueryByInChIKeysIgnoringProtonation(ctx, batchQueries(misses), opts)
+		if err != nil {
+			log.Printf("Error querying by InChIKeys ignoring protonation: %v", err)
+			failBatch(misses)
+			return
+		}
+		firstTwoBlocks := func(q string) string { return q[:25] }
+		misses = applyBatch(misses, hits, totals, firstTwoBlocks, "Protonation-insensitive InChIKey")
+	}
+	if len(misses) == 0 {
 		return
 	}
-	result.MatchFound = true
-	result.MatchLevel = "Exact InChI"
-	result.Matches = compounds
+
+	// Fall back to first-block match (first 14 characters of InChIKey)
+	if !allowFirstBlockMatches {
+		for _, result := range misses {
+			result.ErrMsg = "No compound found, first block matches disabled"
+		}
+		return
+	}
+
+	firstBlock := func(q string) string { return q[:14] }
+	blocks := make([]string, len(misses))
+	for i, result := range misses {
+		blocks[i] = firstBlock(result.Query)
+	}
+	hits, totals, err = index.QueryByFirstBlocks(ctx, blocks, opts)
+	if err != nil {
+		log.Printf("Error querying by first blocks: %v", err)
+		failBatch(misses)
+		return
+	}
+	applyBatch(misses, hits, totals, firstBlock, "First Block")
 }
 
-func matchInchiKey(index *model.PubChemIndex, query string, result *model.SingleResult, allowFirstBlockMatches bool, topHitOnly bool) {
+func matchInchiKey(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, allowProtonationMatches bool, allowFirstBlockMatches bool, opts model.QueryOptions) {
 	// Try full InChIKey match first
-	compounds, err := index.QueryByInChIKey(query, topHitOnly)
+	compounds, total, err := index.QueryByInChIKey(ctx, query, opts)
 	if err != nil {
 		log.Printf("Error querying by InChIKey: %v", err)
 		result.MatchFound = false
@@ -59,12 +148,31 @@ func matchInchiKey(index *model.PubChemIndex, query string, result *model.Single
 		result.MatchFound = true
 		result.MatchLevel = "Exact InChIKey"
 		result.Matches = compounds
+		result.TotalHits = total
 		return
 	}
 
+	// Fall back to the first two blocks, ignoring the protonation flag
+	if allowProtonationMatches {
+		compounds, total, err = index.QueryByInChIKeyIgnoringProtonation(ctx, query, opts)
+		if err != nil {
+			log.Printf("Error querying by InChIKey ignoring protonation: %v", err)
+			result.MatchFound = false
+			result.ErrMsg = "Internal server error"
+			return
+		}
+		if len(compounds) > 0 {
+			result.MatchFound = true
+			result.MatchLevel = "Protonation-insensitive InChIKey"
+			result.Matches = compounds
+			result.TotalHits = total
+			return
+		}
+	}
+
 	// Fall back to first-block match (first 14 characters of InChIKey)
 	if allowFirstBlockMatches {
-		compounds, err = index.QueryByFirstBlock(query[:14], topHitOnly)
+		compounds, total, err = index.QueryByFirstBlock(ctx, query[:14], opts)
 		if err != nil {
 			log.Printf("Error querying by first block: %v", err)
 			result.MatchFound = false
@@ -79,6 +187,7 @@ func matchInchiKey(index *model.PubChemIndex, query string, result *model.Single
 		result.MatchFound = true
 		result.MatchLevel = "First Block"
 		result.Matches = compounds
+		result.TotalHits = total
 	} else {
 		result.MatchFound = false
 		result.ErrMsg = "No compound found, first block matches disabled"
@@ -86,8 +195,8 @@ func matchInchiKey(index *model.PubChemIndex, query string, result *model.Single
 	}
 }
 
-func matchSmiles(index *model.PubChemIndex, query string, result *model.SingleResult, allowFirstBlockMatches bool, topHitOnly bool, allowRdkitConversion bool) {
-	compounds, err := index.QueryBySmiles(query, topHitOnly)
+func matchSmiles(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, allowProtonationMatches bool, allowFirstBlockMatches bool, opts model.QueryOptions, allowRdkitConversion bool) {
+	compounds, total, err := index.QueryBySmiles(ctx, query, opts)
 	if err != nil {
 		log.Printf("Error querying by SMILES: %v", err)
 		result.MatchFound = false
@@ -98,6 +207,7 @@ func matchSmiles(index *model.PubChemIndex, query string, result *model.SingleRe
 		result.MatchFound = true
 		result.MatchLevel = "Exact SMILES"
 		result.Matches = compounds
+		result.TotalHits = total
 		return
 	}
 
@@ -122,20 +232,31 @@ func matchSmiles(index *model.PubChemIndex, query string, result *model.SingleRe
 		return
 	}
 	if inchikey != "" {
-		matchInchiKey(index, inchikey, result, allowFirstBlockMatches, topHitOnly)
+		matchInchiKey(ctx, index, inchikey, result, allowProtonationMatches, allowFirstBlockMatches, opts)
 		if result.MatchFound {
 			result.QueryType = "converted_smiles"
 			result.ConvertedQuery = inchikey
 			return
 		}
+		// Keep the error, so that matchComponents doesn't retry a failing query by parts
+		if result.ErrMsg == "Internal server error" {
+			return
+		}
 	}
 
 	result.MatchFound = false
 	result.ErrMsg = "No compound found"
 }
 
-func matchFormula(index *model.PubChemIndex, query string, result *model.SingleResult, topHitOnly bool) {
-	compounds, err := index.QueryByFormula(query, topHitOnly)
+// matchFormula looks up a molecular formula in Hill order, so "OH2" or "h2o" find H2O.
+//   Queries that don't parse as a formula, or with normalize unset, are looked up as given
+func matchFormula(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, opts model.QueryOptions, normalize bool) {
+	formula := query
+	if normalized, err := model.NormalizeFormula(query); err == nil && normalize {
+		formula = normalized
+	}
+
+	compounds, total, err := index.QueryByFormula(ctx, formula, opts)
 	if err != nil {
 		log.Printf("Error querying by formula: %v", err)
 		result.MatchFound = false
@@ -147,13 +268,97 @@ func matchFormula(index *model.PubChemIndex, query string, result *model.SingleR
 		result.ErrMsg = "No compound found"
 		return
 	}
+	if formula != query {
+		result.ConvertedQuery = formula
+	}
 	result.MatchFound = true
 	result.MatchLevel = "Exact Formula"
 	result.Matches = compounds
+	result.TotalHits = total
+}
+
+// xrefMatchLevels names the match level of each cross-reference source
+var xrefMatchLevels = map[string]string{
+	model.XrefCAS:   "Exact CAS",
+	model.XrefHMDB:  "Exact HMDB ID",
+	model.XrefKEGG:  "Exact KEGG ID",
+	model.XrefChEBI: "Exact ChEBI ID",
+}
+
+// matchXref looks up a CAS number, HMDB, KEGG or ChEBI ID in the cross-references
+func matchXref(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, opts model.QueryOptions) {
+	source, xref, err := model.ParseXref(query)
+	if err != nil || source == "" {
+		result.MatchFound = false
+		result.ErrMsg = "Invalid query type, could not identify, see documentation"
+		return
+	}
+
+	compounds, total, err := index.QueryByXref(ctx, source, xref, opts)
+	if err != nil {
+		log.Printf("Error querying by xref: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+	if xref != query {
+		result.ConvertedQuery = xref
+	}
+	result.MatchFound = true
+	result.MatchLevel = xrefMatchLevels[source]
+	result.Matches = compounds
+	result.TotalHits = total
+}
+
+// attachXrefs sets the cross-references of every match. They are extra information,
+//   so a failure is only logged
+func attachXrefs(ctx context.Context, index model.CompoundIndex, results []*model.SingleResult) {
+	var compounds []*model.Compound
+	for _, result := range results {
+		compounds = append(compounds, allMatches(result)...)
+	}
+	if len(compounds) == 0 {
+		return
+	}
+	if err := index.AttachXrefs(ctx, compounds); err != nil {
+		log.Printf("Error attaching xrefs: %v", err)
+	}
+}
+
+// matchFormulaRange searches element count ranges such as "formula:C6-8H10-14O2-4N0S0"
+func matchFormulaRange(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, opts model.QueryOptions) {
+	ranges, err := model.ParseFormulaRange(query[len("formula:"):])
+	if err != nil {
+		result.MatchFound = false
+		result.ErrMsg = "Malformed formula range, see documentation"
+		return
+	}
+
+	compounds, total, err := index.QueryByFormulaRange(ctx, ranges, opts)
+	if err != nil {
+		log.Printf("Error querying by formula range: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+	result.MatchFound = true
+	result.MatchLevel = "Formula Range"
+	result.Matches = compounds
+	result.TotalHits = total
 }
 
-func matchSmilesOrFormula(index *model.PubChemIndex, query string, result *model.SingleResult, allowFirstBlockMatches bool, topHitOnly bool, allowRdkitConversion bool) {
-	matchSmiles(index, query, result, allowFirstBlockMatches, topHitOnly, allowRdkitConversion)
+func matchSmilesOrFormula(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, allowProtonationMatches bool, allowFirstBlockMatches bool, opts model.QueryOptions, allowRdkitConversion bool) {
+	matchSmiles(ctx, index, query, result, allowProtonationMatches, allowFirstBlockMatches, opts, allowRdkitConversion)
 	if result.MatchFound {
 		if result.QueryType != "converted_smiles" {
 			result.QueryType = "smiles"
@@ -164,9 +369,278 @@ func matchSmilesOrFormula(index *model.PubChemIndex, query string, result *model
 		return
 	}
 
+	// A missed SMILES such as "OCC" is not rewritten into another formula ("C2O")
 	result.ErrMsg = ""
-	matchFormula(index, query, result, topHitOnly)
+	matchFormula(ctx, index, query, result, opts, !couldBeSmiles(query))
 	if result.MatchFound {
 		result.QueryType = "formula"
 	}
 }
+
+// defaultMassPpm is the mass tolerance used when neither ppm nor da is given
+const defaultMassPpm = 10.0
+
+// massTolerance is the search window of a mass query, either relative (ppm) or absolute (da)
+type massTolerance struct {
+	ppm float64
+	da  float64
+}
+
+// parseMassTolerance reads the ppm/da request parameters, only one of them may be set
+func parseMassTolerance(ppm, da string) (massTolerance, error) {
+	if ppm != "" && da != "" {
+		return massTolerance{}, errors.New("Only one of ppm or da can be set")
+	}
+	if da != "" {
+		v, err := strconv.ParseFloat(da, 64)
+		if err != nil || v < 0 {
+			return massTolerance{}, errors.New("Invalid da tolerance, must be a non-negative number")
+		}
+		return massTolerance{da: v}, nil
+	}
+	if ppm != "" {
+		v, err := strconv.ParseFloat(ppm, 64)
+		if err != nil || v < 0 {
+			return massTolerance{}, errors.New("Invalid ppm tolerance, must be a non-negative number")
+		}
+		return massTolerance{ppm: v}, nil
+	}
+	return massTolerance{ppm: defaultMassPpm}, nil
+}
+
+// parseRanking reads the rank request parameter: score (default), literature, patent,
+//   cid, or custom weights as custom:<literature weight>,<patent weight>
+func parseRanking(rank string) (model.Ranking, error) {
+	switch rank {
+	case "", "score":
+		return model.RankByScore, nil
+	case "literature":
+		return model.RankByLiterature, nil
+	case "patent":
+		return model.RankByPatent, nil
+	case "cid":
+		return model.RankByCID, nil
+	}
+
+	weights, ok := strings.CutPrefix(rank, "custom:")
+	if !ok {
+		return model.Ranking{}, errors.New("Invalid rank, must be score, literature, patent, cid or custom:<literature weight>,<patent weight>")
+	}
+	lit, pat, _ := strings.Cut(weights, ",")
+	l, lerr := strconv.ParseFloat(lit, 64)
+	p, perr := strconv.ParseFloat(pat, 64)
+	if lerr != nil || perr != nil || !validWeight(l) || !validWeight(p) {
+		return model.Ranking{}, errors.New("Invalid custom rank weights, must be two non-negative numbers")
+	}
+	return model.CustomRanking(l, p), nil
+}
+
+func validWeight(w float64) bool {
+	return w >= 0 && !math.IsInf(w, 1)
+}
+
+// window returns the half-width of the search window around mass, in Da
+func (t massTolerance) window(mass float64) float64 {
+	if t.ppm > 0 {
+		return mass * t.ppm / 1e6
+	}
+	return t.da
+}
+
+// ppmError is the relative error of a theoretical mass against the observed one
+func ppmError(observed, theoretical float64) float64 {
+	return (observed - theoretical) / theoretical * 1e6
+}
+
+func matchMass(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, tolerance massTolerance, opts model.QueryOptions) {
+	mass, err := strconv.ParseFloat(strings.TrimSpace(query[len("mass:"):]), 64)
+	if err != nil || mass <= 0 {
+		result.MatchFound = false
+		result.ErrMsg = "Malformed mass, see documentation"
+		return
+	}
+
+	compounds, total, err := index.QueryByMass(ctx, mass, tolerance.window(mass), opts)
+	if err != nil {
+		log.Printf("Error querying by mass: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+	for _, c := range compounds {
+		e := ppmError(mass, c.ExactMass)
+		c.MassError = &e
+	}
+	result.MatchFound = true
+	result.MatchLevel = "Mass Window"
+	result.Matches = compounds
+	result.TotalHits = total
+}
+
+// matchMz searches an observed m/z against every selected adduct, each hit reports the
+//   adduct that explains it and its ppm error on the m/z
+func matchMz(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, tolerance massTolerance, ionAdducts []Adduct, opts model.QueryOptions) {
+	mz, err := strconv.ParseFloat(strings.TrimSpace(query[len("mz:"):]), 64)
+	if err != nil || mz <= 0 {
+		result.MatchFound = false
+		result.ErrMsg = "Malformed m/z, see documentation"
+		return
+	}
+
+	// Each adduct only needs the hits up to the end of the page, which is applied once merged
+	adductOpts := opts
+	adductOpts.TopHitOnly, adductOpts.MaxHits, adductOpts.Offset = false, 0, 0
+	if opts.TopHitOnly || opts.MaxHits > 0 {
+		adductOpts.MaxHits = opts.Offset + max(opts.MaxHits, 1)
+	}
+
+	var compounds []*model.Compound
+	var total int
+	for _, a := range ionAdducts {
+		mass := a.neutralMass(mz)
+		if mass <= 0 {
+			continue
+		}
+		// The tolerance applies to the m/z, scale it to the neutral mass
+		window := tolerance.window(mz) * float64(abs(a.Charge)) / float64(a.Multimer)
+		hits, adductTotal, err := index.QueryByMass(ctx, mass, window, adductOpts)
+		if err != nil {
+			log.Printf("Error querying by m/z: %v", err)
+			result.MatchFound = false
+			result.ErrMsg = "Internal server error"
+			return
+		}
+		for _, c := range hits {
+			e := ppmError(mz, a.mz(c.ExactMass))
+			c.Adduct = a.Name
+			c.MassError = &e
+		}
+		compounds = append(compounds, hits...)
+		total += adductTotal
+	}
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+
+	// Same ranking as a single lookup: rank first, then the smallest mass error, then the lowest CID
+	slices.SortStableFunc(compounds, func(a, b *model.Compound) int {
+		if sa, sb := opts.Rank.Score(a), opts.Rank.Score(b); sa != sb {
+			if sa > sb {
+				return -1
+			}
+			return 1
+		}
+		ea, eb := math.Abs(*a.MassError), math.Abs(*b.MassError)
+		switch {
+		case ea < eb:
+			return -1
+		case ea > eb:
+			return 1
+		}
+		ca, _ := strconv.Atoi(a.Identifier)
+		cb, _ := strconv.Atoi(b.Identifier)
+		return ca - cb
+	})
+	compounds = opts.Paginate(compounds)
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+
+	result.MatchFound = true
+	result.MatchLevel = "Adduct m/z"
+	result.Matches = compounds
+	result.TotalHits = total
+}
+
+// stripNamePrefix removes the optional "name:" prefix of an explicit name query
+func stripNamePrefix(query string) string {
+	if len(query) >= 5 && strings.EqualFold(query[:5], "name:") {
+		return strings.TrimSpace(query[5:])
+	}
+	return query
+}
+
+func matchName(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, opts model.QueryOptions) {
+	if query == "" {
+		result.MatchFound = false
+		result.ErrMsg = "Malformed name, see documentation"
+		return
+	}
+
+	compounds, total, err := index.QueryByName(ctx, query, opts)
+	if err != nil {
+		log.Printf("Error querying by name: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) > 0 {
+		result.MatchFound = true
+		result.MatchLevel = "Exact Name"
+		result.Matches = compounds
+		result.TotalHits = total
+		return
+	}
+
+	compounds, total, err = index.QueryByNameCaseInsensitive(ctx, query, opts)
+	if err != nil {
+		log.Printf("Error querying by case-insensitive name: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) > 0 {
+		result.MatchFound = true
+		result.MatchLevel = "Case-insensitive Name"
+		result.Matches = compounds
+		result.TotalHits = total
+		return
+	}
+
+	compounds, total, err = index.QueryBySynonym(ctx, query, opts)
+	if err != nil {
+		log.Printf("Error querying by synonym: %v", err)
+		result.MatchFound = false
+		result.ErrMsg = "Internal server error"
+		return
+	}
+	if len(compounds) == 0 {
+		result.MatchFound = false
+		result.ErrMsg = "No compound found"
+		return
+	}
+	result.MatchFound = true
+	result.MatchLevel = "Exact Synonym"
+	result.Matches = compounds
+	result.TotalHits = total
+}
+
+// matchNameFallback retries a structural query that found nothing as a compound name,
+//   e.g. "Caffeine" or "D-Glucose". The original error is kept if the name misses too
+func matchNameFallback(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, opts model.QueryOptions) {
+	if result.MatchFound || result.ErrMsg == "Internal server error" {
+		return
+	}
+
+	nameResult := &model.SingleResult{}
+	matchName(ctx, index, query, nameResult, opts)
+	if !nameResult.MatchFound {
+		return
+	}
+	result.QueryType = "name"
+	result.ConvertedQuery = ""
+	result.MatchFound = true
+	result.MatchLevel = nameResult.MatchLevel
+	result.Matches = nameResult.Matches
+	result.TotalHits = nameResult.TotalHits
+	result.ErrMsg = ""
+}
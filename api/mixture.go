@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"ctslite/model"
+	"slices"
+	"strings"
+)
+
+// splitComponents splits a multi-component SMILES into its distinct fragments, in order
+func splitComponents(smiles string) []string {
+	var fragments []string
+	for _, fragment := range strings.Split(smiles, ".") {
+		fragment = strings.TrimSpace(fragment)
+		if fragment != "" && !slices.Contains(fragments, fragment) {
+			fragments = append(fragments, fragment)
+		}
+	}
+	return fragments
+}
+
+// smilesAtoms counts the heavy atoms of a SMILES fragment and reports whether it has
+// carbon. Atoms are counted from the SMILES itself, without parsing it with RDKit
+func smilesAtoms(smiles string) (heavy int, carbon bool) {
+	for i := 0; i < len(smiles); i++ {
+		var symbol string
+		switch c := smiles[i]; {
+		case c == '[':
+			end := strings.IndexByte(smiles[i:], ']')
+			if end < 0 {
+				end = len(smiles) - i
+			}
+			// Skip the isotope, the element symbol follows
+			symbol = strings.TrimLeft(smiles[i+1:i+end], "0123456789")
+			if len(symbol) > 1 && symbol[1] >= 'a' && symbol[1] <= 'z' {
+				symbol = symbol[:2]
+			} else if len(symbol) > 0 {
+				symbol = symbol[:1]
+			}
+			i += end
+		case strings.HasPrefix(smiles[i:], "Cl"), strings.HasPrefix(smiles[i:], "Br"):
+			symbol = smiles[i : i+2]
+			i++
+		case strings.IndexByte("BCNOPSFIbcnops", c) >= 0:
+			symbol = string(c)
+		default:
+			continue
+		}
+		if symbol == "" || symbol == "H" {
+			continue
+		}
+		heavy++
+		if symbol == "C" || symbol == "c" {
+			carbon = true
+		}
+	}
+	return heavy, carbon
+}
+
+// largestFragment returns the fragment with the most heavy atoms, preferring organic
+// fragments over inorganic ones such as counterions. Ties go to the first fragment
+func largestFragment(fragments []string) string {
+	var largest string
+	largestAtoms, largestOrganic := -1, false
+	for _, fragment := range fragments {
+		atoms, organic := smilesAtoms(fragment)
+		if (organic && !largestOrganic) || (organic == largestOrganic && atoms > largestAtoms) {
+			largest, largestAtoms, largestOrganic = fragment, atoms, organic
+		}
+	}
+	return largest
+}
+
+// matchComponents matches the fragments of a multi-component SMILES (salts, mixtures,
+// hydrates) that missed as a whole, each as a SMILES query of its own. The results of
+// the fragments are the Components of result, which is found if any of them is. With
+// largestFragmentOnly, the query resolves to its largest organic fragment instead
+func matchComponents(ctx context.Context, index model.CompoundIndex, query string, result *model.SingleResult, s matchSettings) {
+	if result.MatchFound || result.ErrMsg == "Internal server error" {
+		return
+	}
+	fragments := splitComponents(query)
+	if len(fragments) < 2 {
+		return
+	}
+
+	if s.largestFragmentOnly {
+		fragment := largestFragment(fragments)
+		component := &model.SingleResult{}
+		matchSmiles(ctx, index, fragment, component, s.allowProtonationMatches, s.allowFirstBlockMatches, s.opts, s.allowRdkitConversion)
+		if !component.MatchFound {
+			result.ErrMsg = component.ErrMsg
+			return
+		}
+		result.ConvertedQuery = fragment
+		result.MatchFound = true
+		result.MatchLevel = "Largest Fragment"
+		result.Matches = component.Matches
+		result.TotalHits = component.TotalHits
+		result.ErrMsg = ""
+		return
+	}
+
+	components := make([]*model.SingleResult, len(fragments))
+	found := false
+	for i, fragment := range fragments {
+		components[i] = &model.SingleResult{Query: fragment, QueryType: "smiles", Rank: result.Rank}
+		matchSmiles(ctx, index, fragment, components[i], s.allowProtonationMatches, s.allowFirstBlockMatches, s.opts, s.allowRdkitConversion)
+		if components[i].ErrMsg == "Internal server error" {
+			result.ErrMsg = components[i].ErrMsg
+			return
+		}
+		found = found || components[i].MatchFound
+	}
+	if !found {
+		return
+	}
+	result.MatchFound = true
+	result.MatchLevel = "Mixture Components"
+	result.Components = components
+	result.ErrMsg = ""
+}
+
+// allMatches returns the matches of a result and of its components
+func allMatches(result *model.SingleResult) []*model.Compound {
+	if len(result.Components) == 0 {
+		return result.Matches
+	}
+	matches := slices.Clip(result.Matches)
+	for _, component := range result.Components {
+		matches = append(matches, component.Matches...)
+	}
+	return matches
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"ctslite/model"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Suggestions need a few characters to narrow the prefix ranges down
+const (
+	minSuggestPrefix    = 3
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// Suggest returns type-ahead completions for compound names, InChIKey first blocks and CIDs
+func Suggest(index model.CompoundIndex, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix := strings.TrimSpace(r.URL.Query().Get("prefix"))
+	if len(prefix) < minSuggestPrefix {
+		http.Error(w, fmt.Sprintf("Prefix must be at least %d characters", minSuggestPrefix), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSuggestLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxSuggestLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, must be between 1 and %d", maxSuggestLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := index.QuerySuggestions(r.Context(), prefix, limit)
+	if err != nil {
+		log.Printf("Error querying suggestions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if suggestions == nil {
+		suggestions = []*model.Suggestion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+		log.Printf("Failed to encode suggestions response: %v", err)
+	}
+}
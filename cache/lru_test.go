@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	// Reading a makes b the least recently used
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v, want 1, true", v, ok)
+	}
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1, true", v, ok)
+	}
+
+	// Replacing a value doesn't evict
+	c.Add("c", 30)
+	if v, _ := c.Get("c"); v != 30 || c.Len() != 2 {
+		t.Errorf("Get(c) = %d with %d entries, want 30 with 2", v, c.Len())
+	}
+
+	c.Purge()
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Errorf("expected an empty LRU after Purge, got %d entries", c.Len())
+	}
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	c := NewLRU[int, string](16)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := (g*1000 + i) % 64
+				if v, ok := c.Get(key); ok && v != fmt.Sprint(key) {
+					t.Errorf("Get(%d) = %q", key, v)
+				}
+				c.Add(key, fmt.Sprint(key))
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Len() > 16 {
+		t.Errorf("LRU holds %d entries, capacity is 16", c.Len())
+	}
+}
@@ -1,18 +1,23 @@
 // Converts a CTS-Lite CSV dataset into a SQLite database
 
 // Usage:
-//   go run build-db.go <input.csv> <output.db>
+//   go run build-db.go [-dataset name] [-synonyms CID-Synonym-filtered.gz] [-xrefs xrefs.tsv] <input.csv> <output.db>
 
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
 	"ctslite/model"
 	"database/sql"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -20,19 +25,39 @@ import (
 
 const batchSize = 100_000
 
+// options holds the optional inputs of a build
+type options struct {
+	// dataset names the build in its metadata, e.g. the PubChem snapshot date. Defaults
+	//   to the CSV file name
+	dataset string
+
+	// synonymsPath is a PubChem CID-Synonym file, one "CID<TAB>synonym" per line
+	synonymsPath string
+
+	// xrefsPath maps compounds to CAS numbers, HMDB, KEGG or ChEBI IDs, one "CID<TAB>id"
+	//   per line, the source of each id is recognized by model.ParseXref
+	xrefsPath string
+}
+
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatalf("Usage: build-db <input.csv> <output.db>")
+	var opts options
+	flag.StringVar(&opts.dataset, "dataset", "", "Dataset name recorded in the metadata (default: CSV file name)")
+	flag.StringVar(&opts.synonymsPath, "synonyms", "", "PubChem CID-Synonym file to ingest (plain or gzipped)")
+	flag.StringVar(&opts.xrefsPath, "xrefs", "", "CID to CAS/HMDB/KEGG/ChEBI mapping file to ingest (plain or gzipped)")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("Usage: build-db [-dataset name] [-synonyms file] [-xrefs file] <input.csv> <output.db>")
 	}
-	csvPath := os.Args[1]
-	dbPath := os.Args[2]
+	csvPath := flag.Arg(0)
+	dbPath := flag.Arg(1)
 
-	if err := run(csvPath, dbPath); err != nil {
+	if err := run(csvPath, dbPath, opts); err != nil {
 		log.Fatalf("build-db failed: %v", err)
 	}
 }
 
-func run(csvPath, dbPath string) error {
+func run(csvPath, dbPath string, opts options) error {
 	start := time.Now()
 
 	f, err := os.Open(csvPath)
@@ -53,6 +78,51 @@ func run(csvPath, dbPath string) error {
 	}
 	defer db.Close()
 
+	// The CSV is hashed as it is read, so the metadata ties the database to its source
+	sum := sha256.New()
+	count, err := load(db, io.TeeReader(f, sum), start)
+	if err != nil {
+		return err
+	}
+
+	dataset := opts.dataset
+	if dataset == "" {
+		dataset = model.DatasetName(csvPath)
+	}
+	if err := model.WriteMetadata(db, model.NewMetadata(dataset, sum.Sum(nil), count)); err != nil {
+		return err
+	}
+
+	if opts.synonymsPath != "" {
+		if err := loadMapping(db, opts.synonymsPath, "synonyms", bulkInsertSynonyms); err != nil {
+			return err
+		}
+	}
+	if opts.xrefsPath != "" {
+		if err := loadMapping(db, opts.xrefsPath, "xrefs", bulkInsertXrefs); err != nil {
+			return err
+		}
+	}
+
+	// Release the exclusive lock before the name index is built on a new connection
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	fmt.Println("Building name index...")
+	nameStart := time.Now()
+	if err := buildNameIndex(dbPath); err != nil {
+		return err
+	}
+	fmt.Printf("Name index built in %.1f minutes\n", time.Since(nameStart).Minutes())
+
+	fmt.Printf("Done. Database written to %s (total %.1f minutes)\n", dbPath, time.Since(start).Minutes())
+	return nil
+}
+
+// load creates the schema, inserts every CSV row and builds the indices. It returns the
+//   number of compounds inserted
+func load(db *sql.DB, f io.Reader, start time.Time) (int, error) {
 	// Pragmas tuned for write-once bulk insert - no crash recovery needed
 	for _, pragma := range []string{
 		"PRAGMA journal_mode = OFF",
@@ -62,14 +132,14 @@ func run(csvPath, dbPath string) error {
 		"PRAGMA temp_store = MEMORY",
 	} {
 		if _, err := db.Exec(pragma); err != nil {
-			return fmt.Errorf("failed to apply pragma %q: %w", pragma, err)
+			return 0, fmt.Errorf("failed to apply pragma %q: %w", pragma, err)
 		}
 	}
 
 	// Create table without indices first - building indices after all data is
 	//   inserted is much faster than maintaining them row-by-row
 	if _, err := db.Exec(model.CreateTableSQL); err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+		return 0, fmt.Errorf("failed to create table: %w", err)
 	}
 
 	reader := csv.NewReader(f)
@@ -77,26 +147,72 @@ func run(csvPath, dbPath string) error {
 
 	count, err := bulkInsert(db, reader, batchSize)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	fmt.Printf("Inserted %d compounds in %.1f minutes\n", count, time.Since(start).Minutes())
 
 	fmt.Println("Building indices...")
 	indexStart := time.Now()
 	if _, err := db.Exec(model.CreateIndexSQL); err != nil {
-		return fmt.Errorf("failed to create indices: %w", err)
+		return 0, fmt.Errorf("failed to create indices: %w", err)
 	}
 	fmt.Printf("Indices built in %.1f minutes\n", time.Since(indexStart).Minutes())
+	return count, nil
+}
 
-	fmt.Printf("Done. Database written to %s (total %.1f minutes)\n", dbPath, time.Since(start).Minutes())
+// loadMapping ingests a synonyms or xrefs file, dropping entries of compounds not in the
+//   database. It runs after the indices are built since the inserts look up each identifier
+func loadMapping(db *sql.DB, path, kind string, insert func(*sql.DB, io.Reader, int) (int, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", kind, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to read gzipped %s: %w", kind, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	fmt.Printf("Inserting %s...\n", kind)
+	start := time.Now()
+	count, err := insert(db, r, batchSize)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Inserted %d %s in %.1f minutes\n", count, kind, time.Since(start).Minutes())
+	return nil
+}
+
+// buildNameIndex creates the FTS5 name index. mattn/go-sqlite3 only ships FTS5
+//   behind the sqlite_fts5 build tag, so this step goes through the pure Go
+//   driver that model already registers
+func buildNameIndex(dbPath string) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database for name index: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(model.CreateNameIndexSQL); err != nil {
+		return fmt.Errorf("failed to create name index: %w", err)
+	}
 	return nil
 }
 
 // bulkInsert inserts all CSV rows using batched transactions for performance
 // CSV column order: identifier, literature_count, patent_count,
-//   molecular_formula, smiles, inchi, inchikey, exact_mass, compound_name
+//   molecular_formula, smiles, inchi, inchikey, exact_mass, compound_name, then the
+//   property columns: charge, molecular_weight, xlogp, tpsa, hbond_donor_count,
+//   hbond_acceptor_count, heavy_atom_count. Datasets built before the properties were
+//   fetched end after compound_name, their properties are NULL
 func bulkInsert(db *sql.DB, reader *csv.Reader, batchSize int) (int, error) {
-	tx, stmt, err := beginBatch(db)
+	tx, stmt, err := beginBatch(db, model.InsertSQL)
 	if err != nil {
 		return 0, err
 	}
@@ -112,9 +228,9 @@ func bulkInsert(db *sql.DB, reader *csv.Reader, batchSize int) (int, error) {
 			return 0, fmt.Errorf("failed to read CSV row: %w", err)
 		}
 
-		if len(line) != 9 {
+		if len(line) != model.CSVFields && len(line) != model.LegacyCSVFields {
 			tx.Rollback()
-			return 0, fmt.Errorf("row %d has %d fields, expected 9", count+1, len(line))
+			return 0, fmt.Errorf("row %d has %d fields, expected %d or %d", count+1, len(line), model.CSVFields, model.LegacyCSVFields)
 		}
 
 		// Skip lines without inchikeys
@@ -122,18 +238,26 @@ func bulkInsert(db *sql.DB, reader *csv.Reader, batchSize int) (int, error) {
 			continue
 		}
 
-		if _, err := stmt.Exec(
+		props, err := model.ParseProperties(line[model.LegacyCSVFields:])
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("row %d: %w", count+1, err)
+		}
+
+		args := append([]any{
 			line[0], // identifier
 			line[6], // inchikey
 			line[6][:14], // first_block
 			line[5], // inchi
+			model.SkeletonInChI(line[5]), // skeleton_inchi
 			line[4], // smiles
 			line[8], // compound_name
 			line[3], // molecular_formula
 			line[7], // exact_mass
 			line[1], // literature_count
 			line[2], // patent_count
-		); err != nil {
+		}, props.Args()...)
+		if _, err := stmt.Exec(append(args, model.ElementCounts(line[3])...)...); err != nil {
 			tx.Rollback()
 			return 0, fmt.Errorf("failed to insert row %d: %w", count+1, err)
 		}
@@ -148,7 +272,7 @@ func bulkInsert(db *sql.DB, reader *csv.Reader, batchSize int) (int, error) {
 			if count%(batchSize*10) == 0 {
 				fmt.Printf("  %d rows inserted...\n", count)
 			}
-			tx, stmt, err = beginBatch(db)
+			tx, stmt, err = beginBatch(db, model.InsertSQL)
 			if err != nil {
 				return 0, err
 			}
@@ -163,12 +287,93 @@ func bulkInsert(db *sql.DB, reader *csv.Reader, batchSize int) (int, error) {
 	return count, nil
 }
 
-func beginBatch(db *sql.DB) (*sql.Tx, *sql.Stmt, error) {
+// bulkInsertSynonyms inserts "CID<TAB>synonym" lines using batched transactions
+//   and returns the number of synonyms kept
+func bulkInsertSynonyms(db *sql.DB, r io.Reader, batchSize int) (int, error) {
+	return bulkInsertTSV(db, r, batchSize, "synonym", model.InsertSynonymSQL, func(cid, synonym string) []any {
+		if synonym == "" {
+			return nil
+		}
+		return []any{cid, synonym}
+	})
+}
+
+// bulkInsertXrefs inserts "CID<TAB>id" lines using batched transactions and returns the
+//   number of xrefs kept. Ids that model.ParseXref doesn't recognize, or CAS numbers with
+//   a wrong check digit, are skipped
+func bulkInsertXrefs(db *sql.DB, r io.Reader, batchSize int) (int, error) {
+	return bulkInsertTSV(db, r, batchSize, "xref", model.InsertXrefSQL, func(cid, id string) []any {
+		source, xref, err := model.ParseXref(strings.TrimSpace(id))
+		if err != nil || source == "" {
+			return nil
+		}
+		return []any{cid, source, xref}
+	})
+}
+
+// bulkInsertTSV inserts the "CID<TAB>value" lines of r with query, binding the args
+//   returned for each line, or skipping it on nil. It returns the number of rows kept
+func bulkInsertTSV(db *sql.DB, r io.Reader, batchSize int, kind, query string, args func(cid, value string) []any) (int, error) {
+	tx, stmt, err := beginBatch(db, query)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo, count := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		cid, value, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			tx.Rollback()
+			return 0, fmt.Errorf("%s line %d is not tab separated", kind, lineNo)
+		}
+		values := args(cid, value)
+		if values == nil {
+			continue
+		}
+
+		res, err := stmt.Exec(values...)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to insert %s line %d: %w", kind, lineNo, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			count++
+		}
+
+		if lineNo%batchSize == 0 {
+			stmt.Close()
+			if err := tx.Commit(); err != nil {
+				return 0, fmt.Errorf("failed to commit %ss at line %d: %w", kind, lineNo, err)
+			}
+			tx, stmt, err = beginBatch(db, query)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to read %ss: %w", kind, err)
+	}
+
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit final %ss: %w", kind, err)
+	}
+
+	return count, nil
+}
+
+func beginBatch(db *sql.DB, query string) (*sql.Tx, *sql.Stmt, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	stmt, err := tx.Prepare(model.InsertSQL)
+	stmt, err := tx.Prepare(query)
 	if err != nil {
 		tx.Rollback()
 		return nil, nil, fmt.Errorf("failed to prepare insert: %w", err)
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/sha256"
 	"ctslite/model"
 	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 const testCSVContent = `Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName
@@ -33,7 +37,7 @@ func TestRun_Success(t *testing.T) {
 	dbPath := csvPath + ".db"
 	t.Cleanup(func() { os.Remove(dbPath) })
 
-	if err := run(csvPath, dbPath); err != nil {
+	if err := run(csvPath, dbPath, options{}); err != nil {
 		t.Fatalf("run failed: %v", err)
 	}
 
@@ -62,6 +66,227 @@ func TestRun_Success(t *testing.T) {
 	if name != "Water" {
 		t.Errorf("expected Water, got %s", name)
 	}
+
+	// Skeleton InChIs are derived from the InChI column
+	var skeleton string
+	err = db.QueryRow("SELECT skeleton_inchi FROM compounds WHERE identifier = '2'").Scan(&skeleton)
+	if err != nil {
+		t.Fatalf("failed to query skeleton InChI: %v", err)
+	}
+	if skeleton != "InChI=1S/CH4/h1H4" {
+		t.Errorf("expected skeleton InChI=1S/CH4/h1H4, got %s", skeleton)
+	}
+
+	// Element counts are derived from the molecular formula
+	var carbons, hydrogens, nitrogens int
+	err = db.QueryRow("SELECT count_c, count_h, count_n FROM compounds WHERE identifier = '2'").Scan(&carbons, &hydrogens, &nitrogens)
+	if err != nil {
+		t.Fatalf("failed to query element counts: %v", err)
+	}
+	if carbons != 1 || hydrogens != 4 || nitrogens != 0 {
+		t.Errorf("expected CH4 counts 1, 4, 0, got %d, %d, %d", carbons, hydrogens, nitrogens)
+	}
+
+	// The full-text name index must be populated
+	err = db.QueryRow("SELECT COUNT(*) FROM compound_names WHERE compound_names MATCH 'methane'").Scan(&count)
+	if err != nil {
+		t.Fatalf("failed to query name index: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 name index hit, got %d", count)
+	}
+}
+
+func TestRun_Metadata(t *testing.T) {
+	csvPath := writeTempCSV(t)
+	dbPath := csvPath + ".db"
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	if err := run(csvPath, dbPath, options{dataset: "PubChem 2026-10"}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	idx, err := model.OpenSQLiteIndex(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open result DB: %v", err)
+	}
+	defer idx.Close()
+
+	m := idx.Metadata()
+	sum := sha256.Sum256([]byte(testCSVContent))
+	if m.Dataset != "PubChem 2026-10" {
+		t.Errorf("expected dataset PubChem 2026-10, got %q", m.Dataset)
+	}
+	if m.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected source hash %x, got %s", sum, m.SourceSHA256)
+	}
+	if m.RowCount != 2 {
+		t.Errorf("expected row count 2, got %d", m.RowCount)
+	}
+	if m.SchemaVersion != model.SchemaVersion {
+		t.Errorf("expected schema version %d, got %d", model.SchemaVersion, m.SchemaVersion)
+	}
+	if _, err := time.Parse(time.RFC3339, m.BuiltAt); err != nil {
+		t.Errorf("expected an RFC 3339 build time, got %q", m.BuiltAt)
+	}
+}
+
+func TestRun_Properties(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "properties.csv")
+	content := `Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName,Charge,MolecularWeight,XLogP,TPSA,HBondDonorCount,HBondAcceptorCount,HeavyAtomCount
+1,10,2,H2O,O,InChI=1S/H2O/h1H2,MYFAKEINCHIKEY-ISRIGHTHER-E,100,Water,0,18.015,-0.5,1,1,1,1
+2,18,7,CH4,C,InChI=1S/CH4/h1H4,MYFAKEINCHIKEY-ANOTHERONE-E,99,Methane,0,16.043,,0,0,0,1
+`
+	if err := os.WriteFile(csvPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	dbPath := csvPath + ".db"
+
+	if err := run(csvPath, dbPath, options{}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open result DB: %v", err)
+	}
+	defer db.Close()
+
+	var weight, tpsa float64
+	var xlogp sql.NullFloat64
+	var charge, donors, acceptors, heavyAtoms int
+	err = db.QueryRow(`SELECT charge, molecular_weight, xlogp, tpsa, hbond_donor_count, hbond_acceptor_count, heavy_atom_count
+		FROM compounds WHERE identifier = '1'`).Scan(&charge, &weight, &xlogp, &tpsa, &donors, &acceptors, &heavyAtoms)
+	if err != nil {
+		t.Fatalf("failed to query properties: %v", err)
+	}
+	if charge != 0 || weight != 18.015 || xlogp.Float64 != -0.5 || tpsa != 1 || donors != 1 || acceptors != 1 || heavyAtoms != 1 {
+		t.Errorf("unexpected water properties: %d, %v, %v, %v, %d, %d, %d", charge, weight, xlogp.Float64, tpsa, donors, acceptors, heavyAtoms)
+	}
+
+	// Properties missing from the CSV are NULL
+	if err := db.QueryRow(`SELECT xlogp FROM compounds WHERE identifier = '2'`).Scan(&xlogp); err != nil {
+		t.Fatalf("failed to query xlogp: %v", err)
+	}
+	if xlogp.Valid {
+		t.Errorf("expected a NULL xlogp for methane, got %v", xlogp.Float64)
+	}
+
+	// Legacy datasets, without the property columns, leave them all NULL
+	var known int
+	legacyPath := writeTempCSV(t)
+	if err := run(legacyPath, legacyPath+".db", options{}); err != nil {
+		t.Fatalf("run failed on a legacy CSV: %v", err)
+	}
+	legacy, err := sql.Open("sqlite", legacyPath+".db")
+	if err != nil {
+		t.Fatalf("failed to open legacy DB: %v", err)
+	}
+	defer legacy.Close()
+	if err := legacy.QueryRow(`SELECT COUNT(*) FROM compounds WHERE charge IS NOT NULL OR molecular_weight IS NOT NULL`).Scan(&known); err != nil {
+		t.Fatalf("failed to count properties: %v", err)
+	}
+	if known != 0 {
+		t.Errorf("expected no properties in a legacy database, got %d compounds with some", known)
+	}
+}
+
+func TestRun_Synonyms(t *testing.T) {
+	csvPath := writeTempCSV(t)
+	dbPath := csvPath + ".db"
+
+	// CID 3 is not in the CSV, its synonym must be dropped
+	synonymsPath := filepath.Join(t.TempDir(), "synonyms.tsv")
+	synonyms := "1\tDihydrogen monoxide\n1\tOxidane\n2\tMarsh gas\n3\tFormalin\n"
+	if err := os.WriteFile(synonymsPath, []byte(synonyms), 0o644); err != nil {
+		t.Fatalf("failed to write synonyms: %v", err)
+	}
+
+	if err := run(csvPath, dbPath, options{synonymsPath: synonymsPath}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open result DB: %v", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM synonyms").Scan(&count); err != nil {
+		t.Fatalf("failed to count synonyms: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 synonyms, got %d", count)
+	}
+
+	var identifier string
+	err = db.QueryRow("SELECT identifier FROM synonyms WHERE synonym = ?", "Marsh gas").Scan(&identifier)
+	if err != nil {
+		t.Fatalf("failed to query synonym: %v", err)
+	}
+	if identifier != "2" {
+		t.Errorf("expected identifier 2, got %s", identifier)
+	}
+}
+
+func TestRun_Xrefs(t *testing.T) {
+	csvPath := writeTempCSV(t)
+	dbPath := csvPath + ".db"
+
+	// Unrecognized ids, CAS numbers with a wrong check digit and CID 3 (not in the CSV) are dropped
+	xrefsPath := filepath.Join(t.TempDir(), "xrefs.tsv")
+	xrefs := "1\t7732-18-5\n1\tHMDB02111\n1\tchebi:15377\n2\tC01438\n2\t74-82-9\n2\tLMFA00000001\n3\t50-00-0\n"
+	if err := os.WriteFile(xrefsPath, []byte(xrefs), 0o644); err != nil {
+		t.Fatalf("failed to write xrefs: %v", err)
+	}
+
+	if err := run(csvPath, dbPath, options{xrefsPath: xrefsPath}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open result DB: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT identifier, source, xref FROM xrefs ORDER BY identifier, source")
+	if err != nil {
+		t.Fatalf("failed to query xrefs: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var identifier, source, xref string
+		if err := rows.Scan(&identifier, &source, &xref); err != nil {
+			t.Fatalf("failed to scan xref: %v", err)
+		}
+		got = append(got, identifier+" "+source+" "+xref)
+	}
+	want := []string{"1 cas 7732-18-5", "1 chebi CHEBI:15377", "1 hmdb HMDB0002111", "2 kegg C01438"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected xrefs %q, got %q", want, got)
+	}
+}
+
+func TestBulkInsertSynonyms_Malformed(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory DB: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(model.CreateTableSQL); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	_, err = bulkInsertSynonyms(db, strings.NewReader("1 Water\n"), batchSize)
+	if err == nil {
+		t.Error("expected error for a line without a tab, got nil")
+	}
 }
 
 func TestRun_SkipsExistingDB(t *testing.T) {
@@ -76,7 +301,7 @@ func TestRun_SkipsExistingDB(t *testing.T) {
 	dbPath := existing.Name()
 
 	// run() should return nil without overwriting the existing file
-	if err := run(csvPath, dbPath); err != nil {
+	if err := run(csvPath, dbPath, options{}); err != nil {
 		t.Fatalf("expected run to return nil when DB exists, got: %v", err)
 	}
 
@@ -88,7 +313,7 @@ func TestRun_SkipsExistingDB(t *testing.T) {
 }
 
 func TestRun_BadCSVPath(t *testing.T) {
-	err := run("/nonexistent/path/to.csv", t.TempDir()+"/out.db")
+	err := run("/nonexistent/path/to.csv", t.TempDir()+"/out.db", options{})
 	if err == nil {
 		t.Error("expected error for missing CSV, got nil")
 	}
@@ -14,13 +14,14 @@ OUT="$2"
 
 # Reorder columns
 csvcut -c \
-Compound_CID,Linked_PubChem_Literature_Count,Linked_PubChem_Patent_Count,Molecular_Formula,SMILES,InChI,InChIKey,Exact_Mass,Name \
+Compound_CID,Linked_PubChem_Literature_Count,Linked_PubChem_Patent_Count,Molecular_Formula,SMILES,InChI,InChIKey,Exact_Mass,Name,\
+Charge,Molecular_Weight,XLogP,Polar_Area,Hydrogen_Bond_Donor_Count,Hydrogen_Bond_Acceptor_Count,Heavy_Atom_Count \
 "$IN" | \
 
 # Rename columns
 awk 'BEGIN{OFS=","}
 NR==1 {
-  print "Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName"
+  print "Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName,Charge,MolecularWeight,XLogP,TPSA,HBondDonorCount,HBondAcceptorCount,HeavyAtomCount"
   next
 }
 { print }' > "$OUT"
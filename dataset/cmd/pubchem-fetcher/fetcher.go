@@ -28,6 +28,15 @@ type Property struct {
 	PatentCount      int    `json:"PatentCount"`
 	Title            string `json:"Title"`
 	MolecularFormula string `json:"MolecularFormula"`
+
+	// Physicochemical properties, PubChem leaves out the ones it can't compute
+	Charge             int      `json:"Charge"`
+	MolecularWeight    string   `json:"MolecularWeight"`
+	XLogP              *float64 `json:"XLogP"`
+	TPSA               *float64 `json:"TPSA"`
+	HBondDonorCount    *int     `json:"HBondDonorCount"`
+	HBondAcceptorCount *int     `json:"HBondAcceptorCount"`
+	HeavyAtomCount     *int     `json:"HeavyAtomCount"`
 }
 
 type PugResponse struct {
@@ -41,7 +50,8 @@ func fetchPropertiesBatch(cids []int) (*PugResponse, error) {
 	for i, c := range cids {
 		cidStrs[i] = fmt.Sprintf("%d", c)
 	}
-	const endpoint = "https://pubchem.ncbi.nlm.nih.gov/rest/pug/compound/cid/property/InChIKey,InChI,SMILES,ExactMass,LiteratureCount,PatentCount,Title,MolecularFormula/JSON"
+	const endpoint = "https://pubchem.ncbi.nlm.nih.gov/rest/pug/compound/cid/property/InChIKey,InChI,SMILES,ExactMass,LiteratureCount,PatentCount,Title,MolecularFormula," +
+		"Charge,MolecularWeight,XLogP,TPSA,HBondDonorCount,HBondAcceptorCount,HeavyAtomCount/JSON"
 	body := url.Values{"cid": {strings.Join(cidStrs, ",")}}.Encode()
 
 	// Simple retry
@@ -79,6 +89,14 @@ func fetchPropertiesBatch(cids []int) (*PugResponse, error) {
 	return nil, lastErr
 }
 
+// optional formats a property PubChem may leave out, as an empty field when it does
+func optional[T int | float64](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
+
 func readCIDsFromFile(path string) ([]int, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -127,8 +145,9 @@ func main() {
 	w := csv.NewWriter(outFile)
 	defer w.Flush()
 
-	// Header:
-	w.Write([]string{"Compound_CID", "Linked_PubChem_Literature_Count", "Linked_PubChem_Patent_Count", "Molecular_Formula", "SMILES", "InChI", "InChIKey", "Exact_Mass", "Name"})
+	// Header, named like the columns of the PubChem category downloads it is merged with:
+	w.Write([]string{"Compound_CID", "Linked_PubChem_Literature_Count", "Linked_PubChem_Patent_Count", "Molecular_Formula", "SMILES", "InChI", "InChIKey", "Exact_Mass", "Name",
+		"Charge", "Molecular_Weight", "XLogP", "Polar_Area", "Hydrogen_Bond_Donor_Count", "Hydrogen_Bond_Acceptor_Count", "Heavy_Atom_Count"})
 
 	batchSize := 1000
 
@@ -159,6 +178,13 @@ func main() {
 				p.InChIKey,
 				p.ExactMass,
 				p.Title,
+				fmt.Sprintf("%d", p.Charge),
+				p.MolecularWeight,
+				optional(p.XLogP),
+				optional(p.TPSA),
+				optional(p.HBondDonorCount),
+				optional(p.HBondAcceptorCount),
+				optional(p.HeavyAtomCount),
 			}
 			if err := w.Write(row); err != nil {
 				log.Printf("csv write error: %v", err)
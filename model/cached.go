@@ -0,0 +1,266 @@
+package model
+
+import (
+	"context"
+	"ctslite/cache"
+	"sync/atomic"
+)
+
+// CacheStats counts the lookups answered by CachedIndexes. They can share one, so the
+// counts carry over when the index is swapped
+type CacheStats struct {
+	Hits   atomic.Int64
+	Misses atomic.Int64
+}
+
+// CachedIndex answers repeated lookups of an index from a size-bounded LRU cache, for the
+// identifiers found in most uploads. Identifier lookups are cached, keyed by lookup type,
+// value and QueryOptions, while mass, formula range and suggestion lookups pass through.
+// Pages of more than maxCachedHits hits aren't cached, which bounds the cached compounds.
+// The cache belongs to the index it wraps: swapping the index for a new CachedIndex drops
+// it, and requests still running on the previous index can't fill the new cache
+type CachedIndex struct {
+	CompoundIndex
+	lru   *cache.LRU[cacheKey, cacheEntry]
+	stats *CacheStats
+}
+
+// cacheKey identifies a lookup, batch lookups cache each of their values as the
+// corresponding single lookup
+type cacheKey struct {
+	lookup string
+	value  string
+	opts   QueryOptions
+}
+
+// cacheEntry is a page of hits and the total number of hits, an empty page is cached too
+type cacheEntry struct {
+	compounds []*Compound
+	total     int
+}
+
+// maxCachedHits is the largest page of hits cached, larger ones such as all the hits of a
+// formula or name are looked up again
+var maxCachedHits = 10
+
+// cache caches the page of hits of key unless it is too large
+func (idx *CachedIndex) cache(key cacheKey, compounds []*Compound, total int) {
+	if len(compounds) <= maxCachedHits {
+		idx.lru.Add(key, cacheEntry{copyCompounds(compounds), total})
+	}
+}
+
+// NewCachedIndex caches up to size lookups of index, counting hits and misses in stats
+func NewCachedIndex(index CompoundIndex, size int, stats *CacheStats) *CachedIndex {
+	return &CachedIndex{
+		CompoundIndex: index,
+		lru:           cache.NewLRU[cacheKey, cacheEntry](size),
+		stats:         stats,
+	}
+}
+
+// copyCompounds copies the compounds, callers annotate the ones they get
+func copyCompounds(compounds []*Compound) []*Compound {
+	if compounds == nil {
+		return nil
+	}
+	copies := make([]*Compound, len(compounds))
+	for i, c := range compounds {
+		copied := *c
+		copies[i] = &copied
+	}
+	return copies
+}
+
+// lookup answers a single lookup from the cache, or from query and caches it
+func (idx *CachedIndex) lookup(ctx context.Context, lookup, value string, opts QueryOptions, query func() ([]*Compound, int, error)) ([]*Compound, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	key := cacheKey{lookup, value, opts}
+	if e, ok := idx.lru.Get(key); ok {
+		idx.stats.Hits.Add(1)
+		return copyCompounds(e.compounds), e.total, nil
+	}
+	idx.stats.Misses.Add(1)
+
+	compounds, total, err := query()
+	if err != nil {
+		return nil, 0, err
+	}
+	idx.cache(key, compounds, total)
+	return compounds, total, nil
+}
+
+// batch answers a batch lookup from the cache, querying only the values it misses. The
+// hits of value are keyed by keyOf(value), values it rejects are skipped like in the
+// batch lookups of the index
+func (idx *CachedIndex) batch(ctx context.Context, lookup string, values []string, opts QueryOptions,
+	keyOf func(value string) (string, bool), query func(values []string) (map[string][]*Compound, map[string]int, error)) (map[string][]*Compound, map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	hits := make(map[string][]*Compound)
+	totals := make(map[string]int)
+	var misses []string
+	for _, value := range values {
+		key, ok := keyOf(value)
+		if !ok {
+			continue
+		}
+		e, cached := idx.lru.Get(cacheKey{lookup, key, opts})
+		if !cached {
+			misses = append(misses, value)
+			continue
+		}
+		idx.stats.Hits.Add(1)
+		if len(e.compounds) > 0 {
+			hits[key] = copyCompounds(e.compounds)
+			totals[key] = e.total
+		}
+	}
+	if len(misses) == 0 {
+		return hits, totals, nil
+	}
+	idx.stats.Misses.Add(int64(len(misses)))
+
+	missHits, missTotals, err := query(misses)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, value := range misses {
+		key, _ := keyOf(value)
+		idx.cache(cacheKey{lookup, key, opts}, missHits[key], missTotals[key])
+		if compounds, ok := missHits[key]; ok {
+			hits[key] = compounds
+			totals[key] = missTotals[key]
+		}
+	}
+	return hits, totals, nil
+}
+
+// sameKey keys batch hits by the looked up value
+func sameKey(value string) (string, bool) { return value, true }
+
+// firstTwoBlocksKey keys batch hits by the InChIKey without its protonation flag
+func firstTwoBlocksKey(key string) (string, bool) {
+	if len(key) < 25 {
+		return "", false
+	}
+	return key[:25], true
+}
+
+// skeletonKey keys batch hits by the skeleton of the InChI
+func skeletonKey(inchi string) (string, bool) { return SkeletonInChI(inchi), true }
+
+func (idx *CachedIndex) QueryByPubChemID(ctx context.Context, id string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "pubchem_id", id, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByPubChemID(ctx, id, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByInChIKey(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "inchikey", key, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByInChIKey(ctx, key, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByInChIKeyIgnoringProtonation(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "inchikey_ignoring_protonation", key, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByInChIKeyIgnoringProtonation(ctx, key, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByFirstBlock(ctx context.Context, block string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "first_block", block, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByFirstBlock(ctx, block, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "inchi", inchi, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByInChI(ctx, inchi, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryBySkeletonInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "skeleton_inchi", inchi, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryBySkeletonInChI(ctx, inchi, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryBySmiles(ctx context.Context, smiles string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "smiles", smiles, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryBySmiles(ctx, smiles, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByFormula(ctx context.Context, formula string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "formula", formula, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByFormula(ctx, formula, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByName(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "name", name, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByName(ctx, name, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByNameCaseInsensitive(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "name_nocase", name, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByNameCaseInsensitive(ctx, name, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryBySynonym(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "synonym", name, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryBySynonym(ctx, name, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByXref(ctx context.Context, source, xref string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, "xref:"+source, xref, opts, func() ([]*Compound, int, error) {
+		return idx.CompoundIndex.QueryByXref(ctx, source, xref, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByPubChemIDs(ctx context.Context, ids []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "pubchem_id", ids, opts, sameKey, func(ids []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryByPubChemIDs(ctx, ids, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByInChIKeys(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "inchikey", keys, opts, sameKey, func(keys []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryByInChIKeys(ctx, keys, opts)
+	})
+}
+
+// QueryByInChIKeysIgnoringProtonation caches the first two blocks, unlike the single
+// lookup which caches the InChIKey
+func (idx *CachedIndex) QueryByInChIKeysIgnoringProtonation(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "first_two_blocks", keys, opts, firstTwoBlocksKey, func(keys []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryByInChIKeysIgnoringProtonation(ctx, keys, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByFirstBlocks(ctx context.Context, blocks []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "first_block", blocks, opts, sameKey, func(blocks []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryByFirstBlocks(ctx, blocks, opts)
+	})
+}
+
+func (idx *CachedIndex) QueryByInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "inchi", inchis, opts, sameKey, func(inchis []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryByInChIs(ctx, inchis, opts)
+	})
+}
+
+// QueryBySkeletonInChIs caches the skeletons, unlike the single lookup which caches the InChI
+func (idx *CachedIndex) QueryBySkeletonInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, "skeleton", inchis, opts, skeletonKey, func(inchis []string) (map[string][]*Compound, map[string]int, error) {
+		return idx.CompoundIndex.QueryBySkeletonInChIs(ctx, inchis, opts)
+	})
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Fields is a set of compound fields, see QueryOptions.Fields. The zero value is every field
+type Fields uint32
+
+const (
+	FieldIdentifier Fields = 1 << iota
+	FieldInChIKey
+	FieldInChI
+	FieldSmiles
+	FieldCompoundName
+	FieldMolecularFormula
+	FieldExactMass
+	FieldLiteratureCount
+	FieldPatentCount
+	FieldCharge
+	FieldMolecularWeight
+	FieldXLogP
+	FieldTPSA
+	FieldHBondDonorCount
+	FieldHBondAcceptorCount
+	FieldHeavyAtomCount
+	FieldSource
+
+	AllFields = FieldSource<<1 - 1
+)
+
+// fieldNames names each field by its bit, like the JSON of a Compound
+var fieldNames = []string{
+	"identifier", "inchikey", "inchi", "smiles", "compound_name", "molecular_formula",
+	"exact_mass", "literature_count", "patent_count",
+	"charge", "molecular_weight", "xlogp", "tpsa",
+	"hbond_donor_count", "hbond_acceptor_count", "heavy_atom_count",
+	"source",
+}
+
+// rankingFields are always selected: hits are ranked, merged and annotated by them. The
+// source isn't selected either, MultiIndex sets it after the lookup
+const rankingFields = FieldIdentifier | FieldExactMass | FieldLiteratureCount | FieldPatentCount | FieldSource
+
+// projectedFields are the fields lookups only select when asked, in the order of the
+// CASE WHEN ? columns of compoundCols
+var projectedFields = []Fields{
+	FieldInChIKey, FieldInChI, FieldSmiles, FieldCompoundName, FieldMolecularFormula,
+	FieldCharge, FieldMolecularWeight, FieldXLogP, FieldTPSA,
+	FieldHBondDonorCount, FieldHBondAcceptorCount, FieldHeavyAtomCount,
+}
+
+// ParseFields parses a comma-separated list of field names, e.g. "identifier,inchikey".
+// An empty list is every field
+func ParseFields(names string) (Fields, error) {
+	var f Fields
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := FieldByName(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown field %q, must be one of %s", name, strings.Join(fieldNames, ", "))
+		}
+		f |= field
+	}
+	return f, nil
+}
+
+// FieldByName returns the field named name
+func FieldByName(name string) (Fields, bool) {
+	for i, n := range fieldNames {
+		if n == name {
+			return 1 << i, true
+		}
+	}
+	return 0, false
+}
+
+// Has reports whether f holds every field of field
+func (f Fields) Has(field Fields) bool {
+	return f == 0 || f&field == field
+}
+
+// Names returns the names of the fields in f, in Compound order
+func (f Fields) Names() []string {
+	if f == 0 {
+		f = AllFields
+	}
+	names := make([]string, 0, bits.OnesCount32(uint32(f)))
+	for i, name := range fieldNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fieldArgs prepends the args bound by the CASE WHEN ? columns of compoundCols: whether
+// each projected field is selected
+func (o QueryOptions) fieldArgs(args ...any) []any {
+	selected := make([]any, 0, len(projectedFields)+len(args))
+	for _, field := range projectedFields {
+		selected = append(selected, o.Fields.Has(field))
+	}
+	return append(selected, args...)
+}
+
+// project clears the fields of c that lookups with f don't select, like compoundCols does
+func (f Fields) project(c *Compound) {
+	if f.Has(AllFields &^ rankingFields) {
+		return
+	}
+	strs := map[Fields]*string{
+		FieldInChIKey: &c.InChIKey, FieldInChI: &c.InChI, FieldSmiles: &c.Smiles,
+		FieldCompoundName: &c.CompoundName, FieldMolecularFormula: &c.MolecularFormula,
+	}
+	for field, s := range strs {
+		if !f.Has(field) {
+			*s = ""
+		}
+	}
+	ints := map[Fields]**int{
+		FieldCharge: &c.Charge, FieldHBondDonorCount: &c.HBondDonorCount,
+		FieldHBondAcceptorCount: &c.HBondAcceptorCount, FieldHeavyAtomCount: &c.HeavyAtomCount,
+	}
+	for field, v := range ints {
+		if !f.Has(field) {
+			*v = nil
+		}
+	}
+	floats := map[Fields]**float64{FieldMolecularWeight: &c.MolecularWeight, FieldXLogP: &c.XLogP, FieldTPSA: &c.TPSA}
+	for field, v := range floats {
+		if !f.Has(field) {
+			*v = nil
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package model
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// elements holds every element symbol, plus D and T which PubChem formulas use for
+// deuterium and tritium
+var elements = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, e := range strings.Fields(`
+		H He Li Be B C N O F Ne Na Mg Al Si P S Cl Ar K Ca Sc Ti V Cr Mn Fe Co Ni Cu Zn
+		Ga Ge As Se Br Kr Rb Sr Y Zr Nb Mo Tc Ru Rh Pd Ag Cd In Sn Sb Te I Xe Cs Ba La Ce
+		Pr Nd Pm Sm Eu Gd Tb Dy Ho Er Tm Yb Lu Hf Ta W Re Os Ir Pt Au Hg Tl Pb Bi Po At Rn
+		Fr Ra Ac Th Pa U Np Pu Am Cm Bk Cf Es Fm Md No Lr Rf Db Sg Bh Hs Mt Ds Rg Cn Nh Fl
+		Mc Lv Ts Og D T`) {
+		m[e] = true
+	}
+	return m
+}()
+
+// chargePattern matches a trailing charge of at most 4 such as "+", "--" or "+2"
+var chargePattern = regexp.MustCompile(`([+-]{1,4}|[+-][1-4])$`)
+
+// isotope is an element with an explicit mass number, 0 for the natural element
+type isotope struct {
+	symbol string
+	mass   int
+}
+
+// Formula is a parsed molecular formula
+type Formula struct {
+	atoms  map[isotope]int
+	Charge int
+}
+
+// ParseFormula parses a molecular formula such as "C6H12O6", "OH2", "C6 H12 O6",
+// "(CH3)2CO", "C2H3O2-", "Ca+2", "SO4--" or "[13C]H4". Charges go up to 4, after a
+// count they take repeated signs ("SO4-2" could be the count range of "O2-4"). Mass
+// numbers are given in brackets, [2H] and [3H] are read as D and T. All-lowercase
+// formulas with counts ("h2o") are read case-insensitively, preferring one-letter
+// elements ("co2" is CO2)
+func ParseFormula(s string) (*Formula, error) {
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return nil, errors.New("empty formula")
+	}
+
+	f := &Formula{atoms: make(map[isotope]int)}
+	if m := chargePattern.FindString(s); m != "" {
+		s = s[:len(s)-len(m)]
+		// "O2-4" reads as a count range rather than a charge, such charges take repeated signs
+		if len(m) > 1 && m[1] != m[0] && s != "" && '0' <= s[len(s)-1] && s[len(s)-1] <= '9' {
+			return nil, fmt.Errorf("ambiguous charge %q after a count in formula, write it as %s", m, strings.Repeat(m[:1], int(m[1]-'0')))
+		}
+		sign := 1
+		if m[0] == '-' {
+			sign = -1
+		}
+		if n, err := strconv.Atoi(m[1:]); err == nil {
+			f.Charge = sign * n
+		} else {
+			f.Charge = sign * len(m)
+		}
+	}
+
+	if isLowercaseFormula(s) {
+		s = recase(s)
+	}
+
+	p := &formulaParser{s: s}
+	atoms, err := p.group()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q in formula at %d", p.s[p.pos], p.pos)
+	}
+	for a, n := range atoms {
+		if n > 0 {
+			f.atoms[a] = n
+		}
+	}
+	if len(f.atoms) == 0 {
+		return nil, errors.New("formula has no atoms")
+	}
+	return f, nil
+}
+
+// NormalizeFormula rewrites a molecular formula in Hill order, see ParseFormula
+func NormalizeFormula(s string) (string, error) {
+	f, err := ParseFormula(s)
+	if err != nil {
+		return "", err
+	}
+	return f.String(), nil
+}
+
+// Count returns the number of atoms of an element, all isotopes included
+func (f *Formula) Count(symbol string) int {
+	n := 0
+	for a, c := range f.atoms {
+		if a.symbol == symbol {
+			n += c
+		}
+	}
+	return n
+}
+
+// String writes the formula in Hill order as PubChem does: C, then H, then the other
+// elements alphabetically (all alphabetically without carbon), isotopes after their
+// element, then the charge, e.g. "C2H3O2-" or "Ca+2"
+func (f *Formula) String() string {
+	hasCarbon := f.Count("C") > 0
+	rank := func(a isotope) int {
+		switch {
+		case hasCarbon && a.symbol == "C":
+			return 0
+		case hasCarbon && a.symbol == "H":
+			return 1
+		}
+		return 2
+	}
+
+	atoms := make([]isotope, 0, len(f.atoms))
+	for a := range f.atoms {
+		atoms = append(atoms, a)
+	}
+	slices.SortFunc(atoms, func(a, b isotope) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(a.symbol, b.symbol), cmp.Compare(a.mass, b.mass))
+	})
+
+	var b strings.Builder
+	for _, a := range atoms {
+		if a.mass > 0 {
+			fmt.Fprintf(&b, "[%d%s]", a.mass, a.symbol)
+		} else {
+			b.WriteString(a.symbol)
+		}
+		if n := f.atoms[a]; n > 1 {
+			b.WriteString(strconv.Itoa(n))
+		}
+	}
+
+	switch {
+	case f.Charge == 1:
+		b.WriteString("+")
+	case f.Charge == -1:
+		b.WriteString("-")
+	case f.Charge > 1:
+		b.WriteString("+" + strconv.Itoa(f.Charge))
+	case f.Charge < -1:
+		b.WriteString(strconv.Itoa(f.Charge))
+	}
+	return b.String()
+}
+
+// formulaParser reads element counts, (groups) and [isotopes] with multipliers
+type formulaParser struct {
+	s   string
+	pos int
+}
+
+// group parses until the end of the string or a closing parenthesis
+func (p *formulaParser) group() (map[isotope]int, error) {
+	atoms := make(map[isotope]int)
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == ')':
+			return atoms, nil
+
+		case c == '(':
+			p.pos++
+			inner, err := p.group()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+				return nil, errors.New("unclosed parenthesis in formula")
+			}
+			p.pos++
+			n := p.count()
+			for a, c := range inner {
+				atoms[a] += c * n
+			}
+
+		case c == '[':
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return nil, errors.New("unclosed bracket in formula")
+			}
+			a, err := parseIsotope(p.s[p.pos+1 : p.pos+end])
+			if err != nil {
+				return nil, err
+			}
+			p.pos += end + 1
+			atoms[a] += p.count()
+
+		case 'A' <= c && c <= 'Z':
+			symbol := p.s[p.pos : p.pos+1]
+			if p.pos+1 < len(p.s) && 'a' <= p.s[p.pos+1] && p.s[p.pos+1] <= 'z' {
+				symbol = p.s[p.pos : p.pos+2]
+			}
+			if !elements[symbol] {
+				return nil, fmt.Errorf("unknown element %q in formula", symbol)
+			}
+			p.pos += len(symbol)
+			atoms[isotope{symbol: symbol}] += p.count()
+
+		default:
+			return nil, fmt.Errorf("unexpected %q in formula at %d", c, p.pos)
+		}
+	}
+	return atoms, nil
+}
+
+// count reads an optional multiplier, 1 if absent
+func (p *formulaParser) count() int {
+	start := p.pos
+	for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+// parseIsotope reads the inside of a bracket such as "13C"
+func parseIsotope(s string) (isotope, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 || !elements[s[i:]] {
+		return isotope{}, fmt.Errorf("invalid isotope [%s] in formula", s)
+	}
+	mass, _ := strconv.Atoi(s[:i])
+	switch {
+	case s[i:] == "H" && mass == 2:
+		return isotope{symbol: "D"}, nil
+	case s[i:] == "H" && mass == 3:
+		return isotope{symbol: "T"}, nil
+	}
+	return isotope{symbol: s[i:], mass: mass}, nil
+}
+
+// isLowercaseFormula reports whether s is a short lowercase formula such as "h2o" or
+// "c6h12o6": letters and counts only, with a count so that it can't be a name like
+// "unknown" and each element once so that it can't be an aromatic SMILES like "c1ccccc1"
+func isLowercaseFormula(s string) bool {
+	if !strings.ContainsAny(s, "0123456789") || strings.ContainsFunc(s, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, run := range strings.FieldsFunc(s, unicode.IsDigit) {
+		symbols, ok := splitElements(run)
+		if !ok {
+			return false
+		}
+		for _, symbol := range symbols {
+			if seen[symbol] {
+				return false
+			}
+			seen[symbol] = true
+		}
+	}
+	return true
+}
+
+// recase capitalizes every run of letters of s as element symbols, see isLowercaseFormula
+func recase(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] < 'a' || s[i] > 'z' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && 'a' <= s[j] && s[j] <= 'z' {
+			j++
+		}
+		symbols, _ := splitElements(s[i:j])
+		b.WriteString(strings.Join(symbols, ""))
+		i = j
+	}
+	return b.String()
+}
+
+// splitElements splits lowercase letters into element symbols, preferring one-letter ones
+func splitElements(run string) ([]string, bool) {
+	if run == "" {
+		return nil, true
+	}
+	for n := 1; n <= 2 && n <= len(run); n++ {
+		symbol := strings.ToUpper(run[:1]) + run[1:n]
+		if !elements[symbol] {
+			continue
+		}
+		if rest, ok := splitElements(run[n:]); ok {
+			return append([]string{symbol}, rest...), true
+		}
+	}
+	return nil, false
+}
+
+// countedElements are the elements with a count_<element> column in compounds, in
+// InsertSQL order: CHNOPS and the halogens
+var countedElements = []string{"C", "H", "N", "O", "P", "S", "F", "Cl", "Br", "I"}
+
+// elementCountColumn returns the count column of element, if it has one
+func elementCountColumn(element string) (string, bool) {
+	if !slices.Contains(countedElements, element) {
+		return "", false
+	}
+	return "count_" + strings.ToLower(element), true
+}
+
+// ElementCounts returns the count column values of a molecular formula for InsertSQL,
+// all NULL if the formula can't be parsed so that no formula range matches it
+func ElementCounts(formula string) []any {
+	counts := make([]any, len(countedElements))
+	f, err := ParseFormula(formula)
+	if err != nil {
+		return counts
+	}
+	for i, e := range countedElements {
+		counts[i] = f.Count(e)
+	}
+	return counts
+}
+
+// ElementRange bounds the count of an element, both ends included
+type ElementRange struct {
+	Element string
+	Min     int
+	Max     int
+}
+
+// elementRangePattern matches one element of a formula range such as "C6-8", "N0" or "O"
+var elementRangePattern = regexp.MustCompile(`^([A-Z][a-z]?)(\d*)(?:-(\d+))?`)
+
+// ParseFormulaRange parses a formula range such as "C6-8 H10-14 O2-4 N0 S0" (spaces are
+// optional). An element without a count stands for exactly one atom, like in a formula
+func ParseFormulaRange(s string) ([]ElementRange, error) {
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return nil, errors.New("empty formula range")
+	}
+
+	var ranges []ElementRange
+	for s != "" {
+		m := elementRangePattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil, fmt.Errorf("unexpected %q in formula range", s)
+		}
+		s = s[len(m[0]):]
+
+		r := ElementRange{Element: m[1], Min: 1, Max: 1}
+		if _, ok := elementCountColumn(r.Element); !ok {
+			return nil, fmt.Errorf("element %q can't be searched by count", r.Element)
+		}
+		if slices.ContainsFunc(ranges, func(o ElementRange) bool { return o.Element == r.Element }) {
+			return nil, fmt.Errorf("element %q is given twice", r.Element)
+		}
+		if m[2] != "" {
+			r.Min, _ = strconv.Atoi(m[2])
+			r.Max = r.Min
+		}
+		if m[3] != "" {
+			if m[2] == "" {
+				return nil, fmt.Errorf("range of %q has no minimum", r.Element)
+			}
+			r.Max, _ = strconv.Atoi(m[3])
+		}
+		if r.Min > r.Max {
+			return nil, fmt.Errorf("range of %q is empty", r.Element)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
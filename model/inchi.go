@@ -0,0 +1,32 @@
+package model
+
+import (
+	"strings"
+)
+
+// SkeletonInChI strips the stereo (/b, /t, /m, /s) and isotopic layers of an InChI,
+// keeping the formula, connectivity, hydrogen and charge layers. Layers following the
+// isotopic one (fixed H, reconnected metals) only exist in non-standard InChIs and are
+// dropped too, e.g. "InChI=1S/C2H4O2/c1-2(3)4/h1H3,(H,3,4)/i1+1" -> "InChI=1S/C2H4O2/c1-2(3)4/h1H3,(H,3,4)"
+func SkeletonInChI(inchi string) string {
+	layers := strings.Split(inchi, "/")
+	if len(layers) <= 2 {
+		return inchi
+	}
+
+	// The version and formula layers have no prefix
+	kept := layers[:2]
+	for _, layer := range layers[2:] {
+		if layer == "" {
+			continue
+		}
+		switch layer[0] {
+		case 'b', 't', 'm', 's':
+			continue
+		case 'i', 'f', 'r':
+			return strings.Join(kept, "/")
+		}
+		kept = append(kept, layer)
+	}
+	return strings.Join(kept, "/")
+}
@@ -0,0 +1,43 @@
+package model
+
+import "context"
+
+// CompoundIndex is a store of compounds the API can match queries against. Single lookups
+// return a page of hits (see QueryOptions) and the total number of hits, batch lookups
+// return both keyed by looked up value, leaving out values without hits. Lookups stop
+// with the context's error once it is done
+type CompoundIndex interface {
+	QueryByPubChemID(ctx context.Context, id string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByInChIKey(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByInChIKeyIgnoringProtonation(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByFirstBlock(ctx context.Context, block string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error)
+	QueryBySkeletonInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error)
+	QueryBySmiles(ctx context.Context, smiles string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByFormula(ctx context.Context, formula string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByFormulaRange(ctx context.Context, ranges []ElementRange, opts QueryOptions) ([]*Compound, int, error)
+	QueryByMass(ctx context.Context, mass, tolerance float64, opts QueryOptions) ([]*Compound, int, error)
+	QueryByName(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByNameCaseInsensitive(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error)
+	QueryBySynonym(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error)
+	QueryByXref(ctx context.Context, source, xref string, opts QueryOptions) ([]*Compound, int, error)
+
+	QueryByPubChemIDs(ctx context.Context, ids []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+	QueryByInChIKeys(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+	QueryByInChIKeysIgnoringProtonation(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+	QueryByFirstBlocks(ctx context.Context, blocks []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+	QueryByInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+	QueryBySkeletonInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error)
+
+	AttachXrefs(ctx context.Context, compounds []*Compound) error
+	QuerySuggestions(ctx context.Context, prefix string, limit int) ([]*Suggestion, error)
+	Metadata() *Metadata
+	Close() error
+}
+
+var (
+	_ CompoundIndex = (*PubChemIndex)(nil)
+	_ CompoundIndex = (*MemoryIndex)(nil)
+	_ CompoundIndex = (*CachedIndex)(nil)
+	_ CompoundIndex = (*MultiIndex)(nil)
+)
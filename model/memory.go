@@ -0,0 +1,504 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MemoryIndex is a CompoundIndex held in Go maps, loaded from a CTS-Lite CSV. It answers
+// like PubChemIndex without SQLite, for tests and small deployments. Synonyms and xrefs
+// must be added before the index is shared, lookups are safe for concurrent use
+type MemoryIndex struct {
+	compounds []*memoryCompound
+	byMass    []*memoryCompound // sorted by exact mass
+
+	byPubChemID      map[string][]*memoryCompound
+	byInChIKey       map[string][]*memoryCompound
+	byFirstBlock     map[string][]*memoryCompound
+	byFirstTwoBlocks map[string][]*memoryCompound
+	byInChI          map[string][]*memoryCompound
+	bySkeletonInChI  map[string][]*memoryCompound
+	bySmiles         map[string][]*memoryCompound
+	byFormula        map[string][]*memoryCompound
+	byName           map[string][]*memoryCompound
+	byNameNoCase     map[string][]*memoryCompound
+
+	bySynonym map[string][]*memoryCompound
+	byXref    map[[2]string][]*memoryCompound
+	xrefs     map[string]map[string][]string // identifier -> source -> xrefs
+
+	metadata *Metadata
+}
+
+// memoryCompound is a stored compound, lookups return copies since callers annotate them
+type memoryCompound struct {
+	Compound
+	cid     int
+	formula *Formula // nil if the molecular formula doesn't parse
+	synonym string   // set on the entries of bySynonym
+}
+
+func (m *memoryCompound) copy() *Compound {
+	c := m.Compound
+	c.Synonym = m.synonym
+	return &c
+}
+
+// LoadMemoryIndex reads a CTS-Lite CSV into a MemoryIndex. Rows without an InChIKey are
+// skipped, like build-db does
+// CSV column order: identifier, literature_count, patent_count,
+// molecular_formula, smiles, inchi, inchikey, exact_mass, compound_name, then the
+// optional property columns, see ParseProperties
+func LoadMemoryIndex(csvPath string) (*MemoryIndex, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV: %w", err)
+	}
+	defer f.Close()
+
+	idx := &MemoryIndex{
+		byPubChemID:      make(map[string][]*memoryCompound),
+		byInChIKey:       make(map[string][]*memoryCompound),
+		byFirstBlock:     make(map[string][]*memoryCompound),
+		byFirstTwoBlocks: make(map[string][]*memoryCompound),
+		byInChI:          make(map[string][]*memoryCompound),
+		bySkeletonInChI:  make(map[string][]*memoryCompound),
+		bySmiles:         make(map[string][]*memoryCompound),
+		byFormula:        make(map[string][]*memoryCompound),
+		byName:           make(map[string][]*memoryCompound),
+		byNameNoCase:     make(map[string][]*memoryCompound),
+		bySynonym:        make(map[string][]*memoryCompound),
+		byXref:           make(map[[2]string][]*memoryCompound),
+		xrefs:            make(map[string]map[string][]string),
+	}
+
+	sum := sha256.New()
+	reader := csv.NewReader(io.TeeReader(f, sum))
+	_, _ = reader.Read() // skip header
+
+	for row := 1; ; row++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row: %w", err)
+		}
+		if len(line) != CSVFields && len(line) != LegacyCSVFields {
+			return nil, fmt.Errorf("row %d has %d fields, expected %d or %d", row, len(line), CSVFields, LegacyCSVFields)
+		}
+
+		// Skip lines without inchikeys
+		if line[6] == "" {
+			continue
+		}
+
+		m, err := parseMemoryCompound(line)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		idx.add(m)
+	}
+
+	idx.byMass = slices.Clone(idx.compounds)
+	slices.SortStableFunc(idx.byMass, func(a, b *memoryCompound) int { return cmp.Compare(a.ExactMass, b.ExactMass) })
+	idx.metadata = NewMetadata(DatasetName(csvPath), sum.Sum(nil), len(idx.compounds))
+	return idx, nil
+}
+
+func parseMemoryCompound(line []string) (*memoryCompound, error) {
+	literature, err := strconv.ParseFloat(line[1], 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid literature_count %q", line[1])
+	}
+	patent, err := strconv.ParseFloat(line[2], 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patent_count %q", line[2])
+	}
+	mass, err := strconv.ParseFloat(line[7], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exact_mass %q", line[7])
+	}
+	props, err := ParseProperties(line[LegacyCSVFields:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Like byLowestCID, identifiers that aren't CIDs come after all CIDs
+	cid, err := strconv.Atoi(line[0])
+	if err != nil {
+		cid = math.MaxInt
+	}
+	formula, _ := ParseFormula(line[3])
+
+	return &memoryCompound{
+		Compound: Compound{
+			Identifier:       line[0],
+			InChIKey:         line[6],
+			InChI:            line[5],
+			Smiles:           line[4],
+			CompoundName:     line[8],
+			MolecularFormula: line[3],
+			ExactMass:        mass,
+			LiteratureCount:  float32(literature),
+			PatentCount:      float32(patent),
+			Properties:       props,
+		},
+		cid:     cid,
+		formula: formula,
+	}, nil
+}
+
+func (idx *MemoryIndex) add(m *memoryCompound) {
+	idx.compounds = append(idx.compounds, m)
+	idx.byPubChemID[m.Identifier] = append(idx.byPubChemID[m.Identifier], m)
+	idx.byInChIKey[m.InChIKey] = append(idx.byInChIKey[m.InChIKey], m)
+	if len(m.InChIKey) >= 25 {
+		idx.byFirstTwoBlocks[m.InChIKey[:25]] = append(idx.byFirstTwoBlocks[m.InChIKey[:25]], m)
+	}
+	if len(m.InChIKey) >= 14 {
+		idx.byFirstBlock[m.InChIKey[:14]] = append(idx.byFirstBlock[m.InChIKey[:14]], m)
+	}
+	idx.byInChI[m.InChI] = append(idx.byInChI[m.InChI], m)
+	skeleton := SkeletonInChI(m.InChI)
+	idx.bySkeletonInChI[skeleton] = append(idx.bySkeletonInChI[skeleton], m)
+	idx.bySmiles[m.Smiles] = append(idx.bySmiles[m.Smiles], m)
+	idx.byFormula[m.MolecularFormula] = append(idx.byFormula[m.MolecularFormula], m)
+	idx.byName[m.CompoundName] = append(idx.byName[m.CompoundName], m)
+	idx.byNameNoCase[foldASCII(m.CompoundName)] = append(idx.byNameNoCase[foldASCII(m.CompoundName)], m)
+}
+
+// AddSynonym adds a synonym of a compound, ignored if the compound isn't in the index
+// (like InsertSynonymSQL)
+func (idx *MemoryIndex) AddSynonym(identifier, synonym string) {
+	for _, m := range idx.byPubChemID[identifier] {
+		hit := *m
+		hit.synonym = synonym
+		idx.bySynonym[synonym] = append(idx.bySynonym[synonym], &hit)
+	}
+}
+
+// AddXref adds a cross-reference of a compound, ignored if the compound isn't in the
+// index (like InsertXrefSQL)
+func (idx *MemoryIndex) AddXref(identifier, source, xref string) {
+	compounds := idx.byPubChemID[identifier]
+	if len(compounds) == 0 {
+		return
+	}
+	key := [2]string{source, xref}
+	if !slices.Contains(idx.byXref[key], compounds[0]) {
+		idx.byXref[key] = append(idx.byXref[key], compounds...)
+	}
+	if idx.xrefs[identifier] == nil {
+		idx.xrefs[identifier] = make(map[string][]string)
+	}
+	if !slices.Contains(idx.xrefs[identifier][source], xref) {
+		idx.xrefs[identifier][source] = append(idx.xrefs[identifier][source], xref)
+		slices.Sort(idx.xrefs[identifier][source])
+	}
+}
+
+// rank orders hits like orderByRank: by the ranking, then lowest CID
+func rank(hits []*memoryCompound, opts QueryOptions) []*memoryCompound {
+	r := opts.Rank.orDefault()
+	hits = slices.Clone(hits)
+	slices.SortStableFunc(hits, func(a, b *memoryCompound) int {
+		return cmp.Or(cmp.Compare(r.Score(&b.Compound), r.Score(&a.Compound)), cmp.Compare(a.cid, b.cid))
+	})
+	return hits
+}
+
+// page returns copies of the page of ranked hits, with the fields of opts, and their
+// total. Like totalHits, the total is 0 when the page is empty
+func page(ranked []*memoryCompound, opts QueryOptions) ([]*Compound, int, error) {
+	var compounds []*Compound
+	for _, m := range ranked {
+		compounds = append(compounds, m.copy())
+	}
+	compounds = opts.Paginate(compounds)
+	if len(compounds) == 0 {
+		return nil, 0, nil
+	}
+	for _, c := range compounds {
+		opts.Fields.project(c)
+	}
+	return compounds, len(ranked), nil
+}
+
+func (idx *MemoryIndex) lookup(ctx context.Context, hits map[string][]*memoryCompound, value string, opts QueryOptions) ([]*Compound, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return page(rank(hits[value], opts), opts)
+}
+
+// batch is the batch form of lookup, keyed by value. Values without hits in the page are
+// left out, like in PubChemIndex.queryBatch
+func (idx *MemoryIndex) batch(ctx context.Context, hits map[string][]*memoryCompound, values []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	pages := make(map[string][]*Compound)
+	totals := make(map[string]int)
+	for _, value := range values {
+		if _, done := pages[value]; done {
+			continue
+		}
+		compounds, total, err := idx.lookup(ctx, hits, value, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(compounds) > 0 {
+			pages[value] = compounds
+			totals[value] = total
+		}
+	}
+	return pages, totals, nil
+}
+
+func (idx *MemoryIndex) QueryByPubChemID(ctx context.Context, id string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byPubChemID, id, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChIKey(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byInChIKey, key, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChIKeyIgnoringProtonation(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	if len(key) < 25 {
+		return nil, 0, nil
+	}
+	return idx.lookup(ctx, idx.byFirstTwoBlocks, key[:25], opts)
+}
+
+func (idx *MemoryIndex) QueryByFirstBlock(ctx context.Context, block string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byFirstBlock, block, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byInChI, inchi, opts)
+}
+
+func (idx *MemoryIndex) QueryBySkeletonInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.bySkeletonInChI, SkeletonInChI(inchi), opts)
+}
+
+func (idx *MemoryIndex) QueryBySmiles(ctx context.Context, smiles string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.bySmiles, smiles, opts)
+}
+
+func (idx *MemoryIndex) QueryByFormula(ctx context.Context, formula string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byFormula, formula, opts)
+}
+
+func (idx *MemoryIndex) QueryByFormulaRange(ctx context.Context, ranges []ElementRange, opts QueryOptions) ([]*Compound, int, error) {
+	if len(ranges) == 0 {
+		return nil, 0, fmt.Errorf("empty formula range")
+	}
+	for _, r := range ranges {
+		if _, ok := elementCountColumn(r.Element); !ok {
+			return nil, 0, fmt.Errorf("no count column for element %s", r.Element)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var hits []*memoryCompound
+	for _, m := range idx.compounds {
+		if m.formula != nil && !slices.ContainsFunc(ranges, func(r ElementRange) bool {
+			n := m.formula.Count(r.Element)
+			return n < r.Min || n > r.Max
+		}) {
+			hits = append(hits, m)
+		}
+	}
+	return page(rank(hits, opts), opts)
+}
+
+func (idx *MemoryIndex) QueryByMass(ctx context.Context, mass, tolerance float64, opts QueryOptions) ([]*Compound, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	lo, _ := slices.BinarySearchFunc(idx.byMass, mass-tolerance, func(m *memoryCompound, t float64) int {
+		return cmp.Compare(m.ExactMass, t)
+	})
+	var hits []*memoryCompound
+	for _, m := range idx.byMass[lo:] {
+		if m.ExactMass > mass+tolerance {
+			break
+		}
+		hits = append(hits, m)
+	}
+
+	// Ties on rank go to the smallest mass error, like orderByRankThenMassError
+	r := opts.Rank.orDefault()
+	slices.SortStableFunc(hits, func(a, b *memoryCompound) int {
+		return cmp.Or(
+			cmp.Compare(r.Score(&b.Compound), r.Score(&a.Compound)),
+			cmp.Compare(math.Abs(a.ExactMass-mass), math.Abs(b.ExactMass-mass)),
+			cmp.Compare(a.cid, b.cid),
+		)
+	})
+	return page(hits, opts)
+}
+
+func (idx *MemoryIndex) QueryByName(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.byName, name, opts)
+}
+
+// QueryByNameCaseInsensitive ignores ASCII case only, like SQLite's NOCASE
+func (idx *MemoryIndex) QueryByNameCaseInsensitive(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	if ftsPhrase(name) == "" {
+		return nil, 0, nil
+	}
+	return idx.lookup(ctx, idx.byNameNoCase, foldASCII(name), opts)
+}
+
+func (idx *MemoryIndex) QueryBySynonym(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(ctx, idx.bySynonym, name, opts)
+}
+
+func (idx *MemoryIndex) QueryByXref(ctx context.Context, source, xref string, opts QueryOptions) ([]*Compound, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return page(rank(idx.byXref[[2]string{source, xref}], opts), opts)
+}
+
+func (idx *MemoryIndex) QueryByPubChemIDs(ctx context.Context, ids []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, idx.byPubChemID, ids, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChIKeys(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, idx.byInChIKey, keys, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChIKeysIgnoringProtonation(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	blocks := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if len(key) >= 25 {
+			blocks = append(blocks, key[:25])
+		}
+	}
+	return idx.batch(ctx, idx.byFirstTwoBlocks, blocks, opts)
+}
+
+func (idx *MemoryIndex) QueryByFirstBlocks(ctx context.Context, blocks []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, idx.byFirstBlock, blocks, opts)
+}
+
+func (idx *MemoryIndex) QueryByInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(ctx, idx.byInChI, inchis, opts)
+}
+
+func (idx *MemoryIndex) QueryBySkeletonInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	skeletons := make([]string, len(inchis))
+	for i, inchi := range inchis {
+		skeletons[i] = SkeletonInChI(inchi)
+	}
+	return idx.batch(ctx, idx.bySkeletonInChI, skeletons, opts)
+}
+
+func (idx *MemoryIndex) AttachXrefs(ctx context.Context, compounds []*Compound) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, c := range compounds {
+		if xrefs := idx.xrefs[c.Identifier]; xrefs != nil {
+			c.Xrefs = make(map[string][]string, len(xrefs))
+			for source, ids := range xrefs {
+				c.Xrefs[source] = slices.Clone(ids)
+			}
+		}
+	}
+	return nil
+}
+
+// QuerySuggestions approximates the FTS5 name matching of PubChemIndex: each token of
+// prefix must equal a token of the name (ignoring case), the last one may be incomplete
+func (idx *MemoryIndex) QuerySuggestions(ctx context.Context, prefix string, limit int) ([]*Suggestion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Best scored compound per suggested value, like the MAX(score) of the SQL suggestions
+	best := make(map[[2]string]*Suggestion)
+	suggest := func(value, kind string, m *memoryCompound) {
+		score := RankByScore.Score(&m.Compound)
+		if s, ok := best[[2]string{kind, value}]; ok && s.Score >= score {
+			return
+		}
+		best[[2]string{kind, value}] = &Suggestion{Value: value, Type: kind, Identifier: m.Identifier, CompoundName: m.CompoundName, Score: score}
+	}
+
+	tokens := nameTokens(prefix)
+	block := strings.ToUpper(prefix)
+	candidates := 0
+	for _, m := range idx.compounds {
+		// Only the first suggestNameCandidates matching names, like the capped FTS lookup
+		if len(tokens) > 0 && candidates < suggestNameCandidates && matchesNamePrefix(nameTokens(m.CompoundName), tokens) {
+			candidates++
+			suggest(m.CompoundName, "compound_name", m)
+		}
+		if len(prefix) <= 14 && isASCIILetters(prefix) && len(m.InChIKey) >= 14 && inSuggestRange(m.InChIKey[:14], block) {
+			suggest(m.InChIKey[:14], "inchikey_first_block", m)
+		}
+		if isASCIIDigits(prefix) && inSuggestRange(m.Identifier, prefix) {
+			suggest(m.Identifier, "pubchem_cid", m)
+		}
+	}
+
+	suggestions := slices.SortedFunc(maps.Values(best), func(a, b *Suggestion) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Value, b.Value))
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// Metadata describes the CSV the index was loaded from, BuiltAt being the load time
+func (idx *MemoryIndex) Metadata() *Metadata {
+	return idx.metadata
+}
+
+// Close is a no-op, the index is garbage collected
+func (idx *MemoryIndex) Close() error {
+	return nil
+}
+
+// nameTokens splits a name into lowercase letter and digit runs, like the FTS5 tokenizer
+func nameTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// matchesNamePrefix reports whether every query token is a name token, the last one a prefix
+func matchesNamePrefix(name, query []string) bool {
+	last := len(query) - 1
+	for _, t := range query[:last] {
+		if !slices.Contains(name, t) {
+			return false
+		}
+	}
+	return slices.ContainsFunc(name, func(n string) bool { return strings.HasPrefix(n, query[last]) })
+}
+
+// foldASCII lowercases ASCII letters only, like SQLite's NOCASE collation
+func foldASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
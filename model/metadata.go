@@ -0,0 +1,111 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the layout of the databases build-db writes, bump it when the schema
+// changes in a way older servers can't read
+const SchemaVersion = 2
+
+// Metadata identifies the dataset an index serves, so results can be traced back to the
+// PubChem snapshot they came from. It is empty for databases built before it was recorded
+type Metadata struct {
+	Dataset       string `json:"dataset,omitempty"`
+	BuiltAt       string `json:"built_at,omitempty"` // RFC 3339, UTC
+	SourceSHA256  string `json:"source_sha256,omitempty"`
+	RowCount      int    `json:"row_count,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+}
+
+// NewMetadata describes a dataset of rows compounds built now from a CSV with the given
+// SHA-256 sum
+func NewMetadata(dataset string, sourceSHA256 []byte, rows int) *Metadata {
+	return &Metadata{
+		Dataset:       dataset,
+		BuiltAt:       time.Now().UTC().Format(time.RFC3339),
+		SourceSHA256:  hex.EncodeToString(sourceSHA256),
+		RowCount:      rows,
+		SchemaVersion: SchemaVersion,
+	}
+}
+
+// DatasetName is the default name of the dataset in a CSV: its file name without extension
+func DatasetName(csvPath string) string {
+	base := filepath.Base(csvPath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// fields lists the metadata as the key/value rows of the metadata table
+func (m *Metadata) fields() [][2]string {
+	return [][2]string{
+		{"dataset", m.Dataset},
+		{"built_at", m.BuiltAt},
+		{"source_sha256", m.SourceSHA256},
+		{"row_count", strconv.Itoa(m.RowCount)},
+		{"schema_version", strconv.Itoa(m.SchemaVersion)},
+	}
+}
+
+// WriteMetadata stores m in the metadata table, replacing what was there
+func WriteMetadata(db *sql.DB, m *Metadata) error {
+	for _, field := range m.fields() {
+		if _, err := db.Exec(InsertMetadataSQL, field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to write metadata %s: %w", field[0], err)
+		}
+	}
+	return nil
+}
+
+// readMetadata reads the metadata table. Databases built before it existed, or without
+// metadata written, get an empty Metadata
+func readMetadata(db *sql.DB) (*Metadata, error) {
+	m := &Metadata{}
+	var tables int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'metadata'`).Scan(&tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up metadata table: %w", err)
+	}
+	if tables == 0 {
+		return m, nil
+	}
+
+	rows, err := db.Query(`SELECT key, value FROM metadata`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		// Unknown keys are skipped, they may come from a newer build-db
+		switch key {
+		case "dataset":
+			m.Dataset = value
+		case "built_at":
+			m.BuiltAt = value
+		case "source_sha256":
+			m.SourceSHA256 = value
+		case "row_count":
+			m.RowCount, err = strconv.Atoi(value)
+		case "schema_version":
+			m.SchemaVersion, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata %s %q", key, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	return m, nil
+}
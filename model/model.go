@@ -1,9 +1,16 @@
 package model
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
 	"runtime"
+	"slices"
+	"strings"
+	"unicode"
 
 	_ "modernc.org/sqlite" // SQLite driver
 )
@@ -28,9 +35,24 @@ type Compound struct {
 	ExactMass        float64         `json:"exact_mass"`
 	LiteratureCount  float32         `json:"literature_count"`
 	PatentCount      float32         `json:"patent_count"`
+	Properties
+	Source           string          `json:"source,omitempty"` // library of the hit, see MultiIndex
+	Synonym          string          `json:"synonym,omitempty"`
+	Xrefs            map[string][]string `json:"xrefs,omitempty"`
+	Adduct           string          `json:"adduct,omitempty"`
+	MassError        *float64        `json:"mass_error_ppm,omitempty"`
 	ClassyFire       *ClassyFireInfo `json:"classyfire,omitempty"`
 }
 
+// Suggestion is a type-ahead completion of a compound name, InChIKey first block or PubChem CID
+type Suggestion struct {
+	Value        string  `json:"value"`
+	Type         string  `json:"type"`
+	Identifier   string  `json:"identifier"`
+	CompoundName string  `json:"compound_name"`
+	Score        float64 `json:"score"`
+}
+
 type SingleResult struct {
 	Query               string      `json:"query"`
 	QueryType           string      `json:"query_type"`
@@ -38,29 +60,95 @@ type SingleResult struct {
 	MatchFound          bool        `json:"found_match"`
 	MatchLevel          string      `json:"match_level"`
 	Matches             []*Compound `json:"matches"`
+	TotalHits           int         `json:"total_hits,omitempty"`
 	ErrMsg              string      `json:"error_message"`
+	Rank                string      `json:"rank,omitempty"`
+	// Components are the results of the fragments of a multi-component SMILES, matched
+	//   one by one when it misses as a whole
+	Components          []*SingleResult `json:"components,omitempty"`
 }
 
 // PubChemIndex wraps an SQLite database and prepared statements for each lookup type
 type PubChemIndex struct {
 	db           *sql.DB
 	byPubChemID  *sql.Stmt
-	byPubChemID1 *sql.Stmt
 	byInChIKey   *sql.Stmt
-	byInChIKey1  *sql.Stmt
 	byFirstBlock *sql.Stmt
-	byFirstBlock1 *sql.Stmt
+	byFirstTwoBlocks *sql.Stmt
 	byInChI      *sql.Stmt
-	byInChI1     *sql.Stmt
+	bySkeletonInChI *sql.Stmt
 	bySmiles     *sql.Stmt
-	bySmiles1    *sql.Stmt
 	byFormula    *sql.Stmt
-	byFormula1   *sql.Stmt
+	byMass       *sql.Stmt
+	byName       *sql.Stmt
+	byNameNoCase *sql.Stmt
+	bySynonym    *sql.Stmt
+	byXref       *sql.Stmt
+	suggestName       *sql.Stmt
+	suggestFirstBlock *sql.Stmt
+	suggestPubChemID  *sql.Stmt
+	metadata          *Metadata
 }
 
-const selectCols = `SELECT identifier, inchikey, inchi, smiles, compound_name,
-	molecular_formula, exact_mass, literature_count, patent_count FROM compounds`
-const orderByScore = ` ORDER BY (0.7 * literature_count + 0.3 * patent_count) DESC`
+// Lookups select compoundCols, any extra columns, then totalHits: the number of hits
+//   before LIMIT, so a page of hits still tells how many there are
+// The columns QueryOptions.Fields can leave out are only read when their CASE WHEN ?
+//   is bound true, see fieldArgs, so a lookup of a few fields skips the long InChI and
+//   SMILES strings
+const compoundCols = `identifier,
+	CASE WHEN ? THEN inchikey ELSE '' END, CASE WHEN ? THEN inchi ELSE '' END,
+	CASE WHEN ? THEN smiles ELSE '' END, CASE WHEN ? THEN compound_name ELSE '' END,
+	CASE WHEN ? THEN molecular_formula ELSE '' END, exact_mass, literature_count, patent_count,
+	CASE WHEN ? THEN charge END, CASE WHEN ? THEN molecular_weight END,
+	CASE WHEN ? THEN xlogp END, CASE WHEN ? THEN tpsa END,
+	CASE WHEN ? THEN hbond_donor_count END, CASE WHEN ? THEN hbond_acceptor_count END,
+	CASE WHEN ? THEN heavy_atom_count END`
+const totalHits = `COUNT(*) OVER ()`
+const selectCols = `SELECT ` + compoundCols + `, ` + totalHits + ` FROM compounds`
+const scoreExpr = `(0.7 * literature_count + 0.3 * patent_count)`
+
+// Lookups are ordered by a Ranking, binding its (literature, patent) weights after the
+//   selected fields and lookup args, then paged by (limit, offset), see
+//   QueryOptions.lookupArgs
+const rankExpr = `(? * literature_count + ? * patent_count) DESC`
+// Ties go to the lowest CID, then to identifiers that aren't CIDs (library identifiers),
+//   like the cid of MemoryIndex and MultiIndex
+const byLowestCID = `CASE WHEN identifier GLOB '*[^0-9]*' THEN 9223372036854775807 ELSE CAST(identifier AS INTEGER) END`
+const orderByRank = ` ORDER BY ` + rankExpr + `, ` + byLowestCID
+const limitPage = ` LIMIT ? OFFSET ?`
+
+// firstTwoBlocks is an InChIKey without its protonation flag (the last block), it has
+//   its own expression index
+const firstTwoBlocks = `substr(inchikey, 1, 25)`
+
+// Mass lookups take (fields, min, max, weights, target, page), ties on rank go to the
+//   smallest mass error
+const whereMassWindow = ` WHERE exact_mass BETWEEN ? AND ?`
+const orderByRankThenMassError = ` ORDER BY ` + rankExpr + `, ABS(exact_mass - ?), ` + byLowestCID
+
+// Case-insensitive name lookups take (fts phrase, name): the full-text index narrows
+//   the candidates, then the NOCASE comparison keeps only whole-name matches
+const whereNameNoCase = ` WHERE rowid IN (SELECT rowid FROM compound_names WHERE compound_names MATCH ?)
+	AND compound_name = ? COLLATE NOCASE`
+
+// Synonym lookups also return the matched synonym after the compound columns
+const selectColsBySynonym = `SELECT ` + compoundCols + `, synonyms.synonym, ` + totalHits + `
+	FROM compounds JOIN synonyms USING (identifier) WHERE synonyms.synonym = ?`
+
+// Xref lookups take (source, xref)
+const whereXref = ` WHERE identifier IN (SELECT identifier FROM xrefs WHERE source = ? AND xref = ?)`
+
+// Suggestions keep the best scored compound per suggested value (SQLite takes the bare
+//   columns from the row holding the MAX), prefix ranges are the [start, end) of suggestRange
+const suggestNameSQL = `SELECT compound_name, 'compound_name', identifier, compound_name, MAX` + scoreExpr + ` AS score
+	FROM compounds WHERE rowid IN (SELECT rowid FROM compound_names WHERE compound_names MATCH ? LIMIT ?)
+	GROUP BY compound_name ORDER BY score DESC LIMIT ?`
+const suggestFirstBlockSQL = `SELECT first_block, 'inchikey_first_block', identifier, compound_name, MAX` + scoreExpr + ` AS score
+	FROM compounds WHERE first_block >= ? AND first_block < ?
+	GROUP BY first_block ORDER BY score DESC LIMIT ?`
+const suggestPubChemIDSQL = `SELECT identifier, 'pubchem_cid', identifier, compound_name, MAX` + scoreExpr + ` AS score
+	FROM compounds WHERE identifier >= ? AND identifier < ?
+	GROUP BY identifier ORDER BY score DESC LIMIT ?`
 
 // OpenSQLiteIndex opens a pre-built SQLite database for production use
 func OpenSQLiteIndex(dbPath string) (*PubChemIndex, error) {
@@ -93,30 +181,44 @@ func OpenSQLiteIndex(dbPath string) (*PubChemIndex, error) {
 
 // newIndex prepares all statements on an already-configured *sql.DB
 func newIndex(db *sql.DB) (*PubChemIndex, error) {
-	idx := &PubChemIndex{db: db}
+	metadata, err := readMetadata(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	idx := &PubChemIndex{db: db, metadata: metadata}
 
 	stmts := []struct {
 		dest  **sql.Stmt
 		query string
 	}{
-		{&idx.byPubChemID,  selectCols + ` WHERE identifier = ?` + orderByScore},
-		{&idx.byPubChemID1, selectCols + ` WHERE identifier = ?` + orderByScore + ` LIMIT 1`},
-		{&idx.byInChIKey,   selectCols + ` WHERE inchikey = ?` + orderByScore},
-		{&idx.byInChIKey1,  selectCols + ` WHERE inchikey = ?` + orderByScore + ` LIMIT 1`},
-		{&idx.byFirstBlock, selectCols + ` WHERE first_block = ?` + orderByScore},
-		{&idx.byFirstBlock1, selectCols + ` WHERE first_block = ?` + orderByScore + ` LIMIT 1`},
-		{&idx.byInChI,      selectCols + ` WHERE inchi = ?` + orderByScore},
-		{&idx.byInChI1,     selectCols + ` WHERE inchi = ?` + orderByScore + ` LIMIT 1`},
-		{&idx.bySmiles,     selectCols + ` WHERE smiles = ?` + orderByScore},
-		{&idx.bySmiles1,    selectCols + ` WHERE smiles = ?` + orderByScore + ` LIMIT 1`},
-		{&idx.byFormula,    selectCols + ` WHERE molecular_formula = ?` + orderByScore},
-		{&idx.byFormula1,   selectCols + ` WHERE molecular_formula = ?` + orderByScore + ` LIMIT 1`},
+		{&idx.byPubChemID,  selectCols + ` WHERE identifier = ?` + orderByRank + limitPage},
+		{&idx.byInChIKey,   selectCols + ` WHERE inchikey = ?` + orderByRank + limitPage},
+		{&idx.byFirstBlock, selectCols + ` WHERE first_block = ?` + orderByRank + limitPage},
+		{&idx.byFirstTwoBlocks, selectCols + ` WHERE ` + firstTwoBlocks + ` = ?` + orderByRank + limitPage},
+		{&idx.byInChI,      selectCols + ` WHERE inchi = ?` + orderByRank + limitPage},
+		{&idx.bySkeletonInChI, selectCols + ` WHERE skeleton_inchi = ?` + orderByRank + limitPage},
+		{&idx.bySmiles,     selectCols + ` WHERE smiles = ?` + orderByRank + limitPage},
+		{&idx.byFormula,    selectCols + ` WHERE molecular_formula = ?` + orderByRank + limitPage},
+		{&idx.byMass,       selectCols + whereMassWindow + orderByRankThenMassError + limitPage},
+		{&idx.byName,       selectCols + ` WHERE compound_name = ?` + orderByRank + limitPage},
+		{&idx.byNameNoCase, selectCols + whereNameNoCase + orderByRank + limitPage},
+		{&idx.bySynonym,    selectColsBySynonym + orderByRank + limitPage},
+		{&idx.byXref,       selectCols + whereXref + orderByRank + limitPage},
+		{&idx.suggestName,       suggestNameSQL},
+		{&idx.suggestFirstBlock, suggestFirstBlockSQL},
+		{&idx.suggestPubChemID,  suggestPubChemIDSQL},
 	}
 
 	for _, s := range stmts {
 		stmt, err := db.Prepare(s.query)
 		if err != nil {
 			db.Close()
+			// Older databases miss the columns added since they were built
+			if metadata.SchemaVersion < SchemaVersion {
+				return nil, fmt.Errorf("database schema version %d is older than the supported %d, rebuild it with build-db: %w",
+					metadata.SchemaVersion, SchemaVersion, err)
+			}
 			return nil, fmt.Errorf("failed to prepare statement: %w", err)
 		}
 		*s.dest = stmt
@@ -126,46 +228,143 @@ func newIndex(db *sql.DB) (*PubChemIndex, error) {
 }
 
 // CreateTableSQL and CreateIndexSQL are exported so cmd/build-db can reuse them
+// The property columns are NULL when the dataset doesn't have them, see Properties
+// The synonyms table is optional data, it stays empty unless build-db is given a synonyms file
+// The metadata table holds the key/value rows of a Metadata, see WriteMetadata
 const CreateTableSQL = `CREATE TABLE IF NOT EXISTS compounds (
 	identifier        TEXT NOT NULL,
 	inchikey          TEXT NOT NULL,
 	first_block       TEXT NOT NULL,
 	inchi             TEXT NOT NULL,
+	skeleton_inchi    TEXT NOT NULL,
 	smiles            TEXT NOT NULL,
 	compound_name     TEXT NOT NULL,
 	molecular_formula TEXT NOT NULL,
 	exact_mass		  REAL NOT NULL,
 	literature_count  REAL NOT NULL,
-	patent_count      REAL NOT NULL
+	patent_count      REAL NOT NULL,
+	charge               INTEGER,
+	molecular_weight     REAL,
+	xlogp                REAL,
+	tpsa                 REAL,
+	hbond_donor_count    INTEGER,
+	hbond_acceptor_count INTEGER,
+	heavy_atom_count     INTEGER,
+	count_c  INTEGER,
+	count_h  INTEGER,
+	count_n  INTEGER,
+	count_o  INTEGER,
+	count_p  INTEGER,
+	count_s  INTEGER,
+	count_f  INTEGER,
+	count_cl INTEGER,
+	count_br INTEGER,
+	count_i  INTEGER
+);
+CREATE TABLE IF NOT EXISTS synonyms (
+	identifier TEXT NOT NULL,
+	synonym    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS xrefs (
+	identifier TEXT NOT NULL,
+	source     TEXT NOT NULL,
+	xref       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS metadata (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
 )`
 
 const CreateIndexSQL = `
 CREATE INDEX IF NOT EXISTS idx_pubchem_id  ON compounds(identifier);
 CREATE INDEX IF NOT EXISTS idx_inchikey    ON compounds(inchikey);
 CREATE INDEX IF NOT EXISTS idx_first_block ON compounds(first_block);
+CREATE INDEX IF NOT EXISTS idx_first_two_blocks ON compounds(substr(inchikey, 1, 25));
 CREATE INDEX IF NOT EXISTS idx_inchi       ON compounds(inchi);
+CREATE INDEX IF NOT EXISTS idx_skeleton_inchi ON compounds(skeleton_inchi);
 CREATE INDEX IF NOT EXISTS idx_smiles      ON compounds(smiles);
-CREATE INDEX IF NOT EXISTS idx_formula     ON compounds(molecular_formula)`
+CREATE INDEX IF NOT EXISTS idx_formula     ON compounds(molecular_formula);
+CREATE INDEX IF NOT EXISTS idx_exact_mass  ON compounds(exact_mass);
+CREATE INDEX IF NOT EXISTS idx_compound_name ON compounds(compound_name);
+CREATE INDEX IF NOT EXISTS idx_element_counts ON compounds(count_c, count_h, count_n, count_o);
+CREATE INDEX IF NOT EXISTS idx_synonym     ON synonyms(synonym);
+CREATE INDEX IF NOT EXISTS idx_xref        ON xrefs(xref, source);
+CREATE INDEX IF NOT EXISTS idx_xref_identifier ON xrefs(identifier)`
+
+// CreateNameIndexSQL builds the FTS5 full-text index over compound_name. It is
+//   kept apart from CreateIndexSQL because mattn/go-sqlite3 (used by build-db for
+//   the bulk insert) only ships FTS5 behind the sqlite_fts5 build tag
+const CreateNameIndexSQL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS compound_names USING fts5(
+	compound_name, content='compounds', content_rowid='rowid', prefix='2 3'
+);
+INSERT INTO compound_names(compound_names) VALUES ('rebuild')`
 
+// InsertSQL takes the compound columns, then the Args of its Properties, then
+//   ElementCounts of its molecular formula
 const InsertSQL = `INSERT INTO compounds
-	(identifier, inchikey, first_block, inchi, smiles, compound_name, molecular_formula, exact_mass, literature_count, patent_count)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	(identifier, inchikey, first_block, inchi, skeleton_inchi, smiles, compound_name, molecular_formula, exact_mass, literature_count, patent_count,
+	 charge, molecular_weight, xlogp, tpsa, hbond_donor_count, hbond_acceptor_count, heavy_atom_count,
+	 count_c, count_h, count_n, count_o, count_p, count_s, count_f, count_cl, count_br, count_i)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// InsertSynonymSQL only keeps synonyms of compounds in the database, so it must run
+//   after the compounds are inserted and indexed
+const InsertSynonymSQL = `INSERT INTO synonyms (identifier, synonym)
+	SELECT ?1, ?2 WHERE EXISTS (SELECT 1 FROM compounds WHERE identifier = ?1)`
+
+// InsertXrefSQL takes (identifier, source, xref) and, like InsertSynonymSQL, only keeps
+//   cross-references of compounds in the database
+const InsertXrefSQL = `INSERT INTO xrefs (identifier, source, xref)
+	SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM compounds WHERE identifier = ?1)`
+
+// InsertMetadataSQL stores a (key, value) row of the metadata table
+const InsertMetadataSQL = `INSERT OR REPLACE INTO metadata (key, value) VALUES (?, ?)`
 
-// query executes a prepared statement and scans all result rows into Compound pointers
-func (idx *PubChemIndex) query(stmt *sql.Stmt, arg string) ([]*Compound, error) {
-	rows, err := stmt.Query(arg)
+// query executes a lookup statement, returning the page of compounds it selects and
+//   the total number of hits
+func (idx *PubChemIndex) query(ctx context.Context, stmt *sql.Stmt, args ...any) ([]*Compound, int, error) {
+	return idx.queryExtra(ctx, stmt, nil, args...)
+}
+
+// queryExtra is query for statements selecting more columns between compoundCols
+//   and totalHits, extra returns the scan destinations of those columns for each compound
+func (idx *PubChemIndex) queryExtra(ctx context.Context, stmt *sql.Stmt, extra func(c *Compound) []any, args ...any) ([]*Compound, int, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, 0, fmt.Errorf("query failed: %w", err)
 	}
+
+	var total int
+	compounds, err := scanCompounds(rows, func(c *Compound) []any {
+		var dest []any
+		if extra != nil {
+			dest = extra(c)
+		}
+		return append(dest, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return compounds, total, nil
+}
+
+// scanCompounds reads and closes rows selecting compoundCols, then the extra columns
+func scanCompounds(rows *sql.Rows, extra func(c *Compound) []any) ([]*Compound, error) {
 	defer rows.Close()
 
 	var compounds []*Compound
 	for rows.Next() {
 		c := &Compound{}
-		if err := rows.Scan(
+		dest := []any{
 			&c.Identifier, &c.InChIKey, &c.InChI, &c.Smiles, &c.CompoundName,
 			&c.MolecularFormula, &c.ExactMass, &c.LiteratureCount, &c.PatentCount,
-		); err != nil {
+		}
+		dest = append(dest, c.Properties.scanDest()...)
+		if extra != nil {
+			dest = append(dest, extra(c)...)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
 		compounds = append(compounds, c)
@@ -173,46 +372,401 @@ func (idx *PubChemIndex) query(stmt *sql.Stmt, arg string) ([]*Compound, error)
 	return compounds, rows.Err()
 }
 
-func (idx *PubChemIndex) QueryByPubChemID(id string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.byPubChemID1, id)
+func (idx *PubChemIndex) QueryByPubChemID(ctx context.Context, id string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byPubChemID, opts.lookupArgs(id)...)
+}
+
+func (idx *PubChemIndex) QueryByInChIKey(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byInChIKey, opts.lookupArgs(key)...)
+}
+
+func (idx *PubChemIndex) QueryByFirstBlock(ctx context.Context, block string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byFirstBlock, opts.lookupArgs(block)...)
+}
+
+// QueryByInChIKeyIgnoringProtonation returns compounds whose InChIKey only differs
+// from key by the protonation flag
+func (idx *PubChemIndex) QueryByInChIKeyIgnoringProtonation(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	if len(key) < 25 {
+		return nil, 0, nil
 	}
-	return idx.query(idx.byPubChemID, id)
+	return idx.query(ctx, idx.byFirstTwoBlocks, opts.lookupArgs(key[:25])...)
+}
+
+func (idx *PubChemIndex) QueryByInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byInChI, opts.lookupArgs(inchi)...)
+}
+
+// QueryBySkeletonInChI returns compounds whose InChI equals inchi ignoring stereo and
+// isotopic layers, see SkeletonInChI
+func (idx *PubChemIndex) QueryBySkeletonInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.bySkeletonInChI, opts.lookupArgs(SkeletonInChI(inchi))...)
+}
+
+func (idx *PubChemIndex) QueryBySmiles(ctx context.Context, smiles string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.bySmiles, opts.lookupArgs(smiles)...)
+}
+
+func (idx *PubChemIndex) QueryByFormula(ctx context.Context, formula string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byFormula, opts.lookupArgs(formula)...)
+}
+
+// QueryByMass returns compounds whose exact mass lies within tolerance (in Da)
+// of mass, ordered by score and then by absolute mass error
+func (idx *PubChemIndex) QueryByMass(ctx context.Context, mass, tolerance float64, opts QueryOptions) ([]*Compound, int, error) {
+	args := append(opts.rankArgs(opts.fieldArgs(mass-tolerance, mass+tolerance)...), mass)
+	return idx.query(ctx, idx.byMass, opts.pageArgs(args...)...)
 }
 
-func (idx *PubChemIndex) QueryByInChIKey(key string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.byInChIKey1, key)
+// QueryByFormulaRange returns compounds whose element counts all lie within ranges,
+//   elements without a range are unconstrained
+func (idx *PubChemIndex) QueryByFormulaRange(ctx context.Context, ranges []ElementRange, opts QueryOptions) ([]*Compound, int, error) {
+	var conds []string
+	var args []any
+	for _, r := range ranges {
+		column, ok := elementCountColumn(r.Element)
+		if !ok {
+			return nil, 0, fmt.Errorf("no count column for element %s", r.Element)
+		}
+		conds = append(conds, column+` BETWEEN ? AND ?`)
+		args = append(args, r.Min, r.Max)
+	}
+	if len(conds) == 0 {
+		return nil, 0, fmt.Errorf("empty formula range")
+	}
+
+	query := selectCols + ` WHERE ` + strings.Join(conds, ` AND `) + orderByRank + limitPage
+	rows, err := idx.db.QueryContext(ctx, query, opts.lookupArgs(args...)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query failed: %w", err)
 	}
-	return idx.query(idx.byInChIKey, key)
+
+	var total int
+	compounds, err := scanCompounds(rows, func(*Compound) []any { return []any{&total} })
+	if err != nil {
+		return nil, 0, err
+	}
+	return compounds, total, nil
+}
+
+// QueryByName returns compounds whose name is exactly name
+func (idx *PubChemIndex) QueryByName(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byName, opts.lookupArgs(name)...)
 }
 
-func (idx *PubChemIndex) QueryByFirstBlock(block string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.byFirstBlock1, block)
+// QueryByNameCaseInsensitive returns compounds whose name equals name ignoring (ASCII) case
+func (idx *PubChemIndex) QueryByNameCaseInsensitive(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	phrase := ftsPhrase(name)
+	if phrase == "" {
+		return nil, 0, nil
 	}
-	return idx.query(idx.byFirstBlock, block)
+	return idx.query(ctx, idx.byNameNoCase, opts.lookupArgs(phrase, name)...)
 }
 
-func (idx *PubChemIndex) QueryByInChI(inchi string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.byInChI1, inchi)
+// batchChunkSize bounds the IN (...) list of a batch lookup, well below SQLite's
+//   limit on bound parameters
+const batchChunkSize = 500
+
+// queryBatch looks up many values of column (or an indexed expression) with one query per chunk of values. Hits
+//   are keyed by value and paged per value like a single lookup, along with the total
+//   number of hits of each value
+func (idx *PubChemIndex) queryBatch(ctx context.Context, column string, values []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	hits := make(map[string][]*Compound, len(values))
+	totals := make(map[string]int, len(values))
+	values = slices.Compact(slices.Sorted(slices.Values(values)))
+
+	limit, offset := opts.page()
+	for chunk := range slices.Chunk(values, batchChunkSize) {
+		// The window functions apply the single lookup ORDER BY and totalHits within each value
+		query := `SELECT ` + compoundCols + `, batch_key, batch_total FROM (
+			SELECT *, ` + column + ` AS batch_key,
+				ROW_NUMBER() OVER (PARTITION BY ` + column + orderByRank + `) AS hit_rank,
+				COUNT(*) OVER (PARTITION BY ` + column + `) AS batch_total
+			FROM compounds WHERE ` + column + ` IN (?` + strings.Repeat(`, ?`, len(chunk)-1) + `))
+			WHERE hit_rank > ?`
+
+		// The selected fields and window's weights come before the IN (...) values, the page after
+		args := opts.rankArgs(opts.fieldArgs()...)
+		for _, v := range chunk {
+			args = append(args, v)
+		}
+		args = append(args, offset)
+		if limit >= 0 {
+			query += ` AND hit_rank <= ?`
+			args = append(args, offset+limit)
+		}
+		query += ` ORDER BY hit_rank`
+
+		rows, err := idx.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("batch query failed: %w", err)
+		}
+
+		type batchRow struct {
+			key   string
+			total int
+		}
+		rowOf := make(map[*Compound]*batchRow)
+		compounds, err := scanCompounds(rows, func(c *Compound) []any {
+			r := &batchRow{}
+			rowOf[c] = r
+			return []any{&r.key, &r.total}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range compounds {
+			r := rowOf[c]
+			hits[r.key] = append(hits[r.key], c)
+			totals[r.key] = r.total
+		}
 	}
-	return idx.query(idx.byInChI, inchi)
+	return hits, totals, nil
 }
 
-func (idx *PubChemIndex) QueryBySmiles(smiles string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.bySmiles1, smiles)
+// QueryByPubChemIDs is the batch form of QueryByPubChemID, hits and totals are keyed by ID
+func (idx *PubChemIndex) QueryByPubChemIDs(ctx context.Context, ids []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.queryBatch(ctx, "identifier", ids, opts)
+}
+
+// QueryByInChIKeys is the batch form of QueryByInChIKey, hits and totals are keyed by InChIKey
+func (idx *PubChemIndex) QueryByInChIKeys(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.queryBatch(ctx, "inchikey", keys, opts)
+}
+
+// QueryByFirstBlocks is the batch form of QueryByFirstBlock, hits and totals are keyed by first block
+func (idx *PubChemIndex) QueryByFirstBlocks(ctx context.Context, blocks []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.queryBatch(ctx, "first_block", blocks, opts)
+}
+
+// QueryByInChIKeysIgnoringProtonation is the batch form of QueryByInChIKeyIgnoringProtonation,
+//   hits and totals are keyed by the first two blocks of the InChIKeys
+func (idx *PubChemIndex) QueryByInChIKeysIgnoringProtonation(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	blocks := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if len(key) >= 25 {
+			blocks = append(blocks, key[:25])
+		}
 	}
-	return idx.query(idx.bySmiles, smiles)
+	return idx.queryBatch(ctx, firstTwoBlocks, blocks, opts)
+}
+
+// QueryByInChIs is the batch form of QueryByInChI, hits and totals are keyed by InChI
+func (idx *PubChemIndex) QueryByInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.queryBatch(ctx, "inchi", inchis, opts)
 }
 
-func (idx *PubChemIndex) QueryByFormula(formula string, topHitOnly bool) ([]*Compound, error) {
-	if topHitOnly {
-		return idx.query(idx.byFormula1, formula)
+// QueryBySkeletonInChIs is the batch form of QueryBySkeletonInChI, hits and totals are
+//   keyed by the skeleton of each InChI
+func (idx *PubChemIndex) QueryBySkeletonInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	skeletons := make([]string, len(inchis))
+	for i, inchi := range inchis {
+		skeletons[i] = SkeletonInChI(inchi)
 	}
-	return idx.query(idx.byFormula, formula)
+	return idx.queryBatch(ctx, "skeleton_inchi", skeletons, opts)
+}
+
+// QueryBySynonym returns compounds having name as an exact synonym, each with Synonym set
+func (idx *PubChemIndex) QueryBySynonym(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	synonym := func(c *Compound) []any { return []any{&c.Synonym} }
+	return idx.queryExtra(ctx, idx.bySynonym, synonym, opts.lookupArgs(name)...)
+}
+
+// QueryByXref returns compounds having xref as a cross-reference of source, see ParseXref
+func (idx *PubChemIndex) QueryByXref(ctx context.Context, source, xref string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.query(ctx, idx.byXref, opts.lookupArgs(source, xref)...)
+}
+
+// AttachXrefs sets the cross-references of each compound, keyed by source
+func (idx *PubChemIndex) AttachXrefs(ctx context.Context, compounds []*Compound) error {
+	// Batch lookups share compounds between identical queries, each is set once
+	byID := make(map[string][]*Compound)
+	for _, c := range compounds {
+		if !slices.Contains(byID[c.Identifier], c) {
+			byID[c.Identifier] = append(byID[c.Identifier], c)
+		}
+	}
+	ids := slices.Sorted(maps.Keys(byID))
+
+	for chunk := range slices.Chunk(ids, batchChunkSize) {
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := idx.db.QueryContext(ctx, `SELECT DISTINCT identifier, source, xref FROM xrefs
+			WHERE identifier IN (?`+strings.Repeat(`, ?`, len(chunk)-1)+`) ORDER BY source, xref`, args...)
+		if err != nil {
+			return fmt.Errorf("xref query failed: %w", err)
+		}
+
+		for rows.Next() {
+			var id, source, xref string
+			if err := rows.Scan(&id, &source, &xref); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan xref: %w", err)
+			}
+			for _, c := range byID[id] {
+				if c.Xrefs == nil {
+					c.Xrefs = make(map[string][]string)
+				}
+				c.Xrefs[source] = append(c.Xrefs[source], xref)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("xref rows error: %w", err)
+		}
+	}
+	return nil
+}
+
+// QuerySuggestions completes prefix as compound names (by token prefix), InChIKey first
+// blocks and PubChem CIDs, returning at most limit suggestions ranked by score
+func (idx *PubChemIndex) QuerySuggestions(ctx context.Context, prefix string, limit int) ([]*Suggestion, error) {
+	var suggestions []*Suggestion
+
+	if match := ftsPrefixQuery(prefix); match != "" {
+		s, err := idx.suggest(ctx, idx.suggestName, match, suggestNameCandidates, limit)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s...)
+	}
+
+	if len(prefix) <= 14 && isASCIILetters(prefix) {
+		block := strings.ToUpper(prefix)
+		start, end := suggestRange(block)
+		s, err := idx.suggest(ctx, idx.suggestFirstBlock, start, end, limit)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s...)
+	}
+
+	if isASCIIDigits(prefix) {
+		start, end := suggestRange(prefix)
+		s, err := idx.suggest(ctx, idx.suggestPubChemID, start, end, limit)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s...)
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b *Suggestion) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+func (idx *PubChemIndex) suggest(ctx context.Context, stmt *sql.Stmt, args ...any) ([]*Suggestion, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var suggestions []*Suggestion
+	for rows.Next() {
+		s := &Suggestion{}
+		if err := rows.Scan(&s.Value, &s.Type, &s.Identifier, &s.CompoundName, &s.Score); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// ftsPrefixQuery turns a typed prefix into an FTS5 query where every token must match
+// and the last one may be incomplete, e.g. "glucose 6-ph" -> "glucose" "6" "ph"*
+func ftsPrefixQuery(prefix string) string {
+	tokens := strings.FieldsFunc(prefix, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(tokens) == 0 {
+		return ""
+	}
+	for i, t := range tokens {
+		tokens[i] = `"` + t + `"`
+	}
+	return strings.Join(tokens, " ") + "*"
+}
+
+// prefixEnd is the smallest string greater than every string starting with the
+// (ASCII) prefix, so that [prefix, prefixEnd(prefix)) is an index-friendly range
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	b[len(b)-1]++
+	return string(b)
+}
+
+// suggestNameCandidates caps the names matching a prefix that are grouped and scored,
+//   the first ones by rowid, so that a short prefix reads a bounded number of rows
+const suggestNameCandidates = 1000
+
+// minSuggestRange is the shortest CID or first block prefix that is completed, a shorter
+//   one ranges over too much of the table to group it and only suggests the exact value
+const minSuggestRange = 5
+
+// suggestRange is the [start, end) range of the CIDs or first blocks suggested for prefix
+func suggestRange(prefix string) (string, string) {
+	if len(prefix) < minSuggestRange {
+		return prefix, prefix + "\x00"
+	}
+	return prefix, prefixEnd(prefix)
+}
+
+// inSuggestRange reports whether value is in the suggestRange of prefix
+func inSuggestRange(value, prefix string) bool {
+	if len(prefix) < minSuggestRange {
+		return value == prefix
+	}
+	return strings.HasPrefix(value, prefix)
+}
+
+func isASCIILetters(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool { return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') })
+}
+
+func isASCIIDigits(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+}
+
+// ftsPhrase quotes s as a single FTS5 phrase, or returns "" if s has no tokens to match
+func ftsPhrase(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		return ""
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Verify checks that the database can serve lookups: its schema was already checked by
+//   preparing the statements, so this reads a compound through the mass index and the
+//   name index, which fails on a truncated or half-copied file. Databases of a newer
+//   schema version are refused
+func (idx *PubChemIndex) Verify() error {
+	var identifier string
+	err := idx.db.QueryRow(`SELECT identifier FROM compounds ORDER BY exact_mass LIMIT 1`).Scan(&identifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("database has no compounds")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read compounds: %w", err)
+	}
+	var rowid int64
+	err = idx.db.QueryRow(`SELECT rowid FROM compound_names LIMIT 1`).Scan(&rowid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read compound names: %w", err)
+	}
+	if idx.metadata.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the supported %d", idx.metadata.SchemaVersion, SchemaVersion)
+	}
+	return nil
+}
+
+// Metadata describes the dataset the database was built from
+func (idx *PubChemIndex) Metadata() *Metadata {
+	return idx.metadata
 }
 
 // Close releases the database connection and all prepared statements.
@@ -1,9 +1,14 @@
 package model
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -42,6 +47,9 @@ func createTempDB(t *testing.T) string {
 	if _, err := db.Exec(CreateIndexSQL); err != nil {
 		t.Fatalf("failed to create indices: %v", err)
 	}
+	if _, err := db.Exec(CreateNameIndexSQL); err != nil {
+		t.Fatalf("failed to create name index: %v", err)
+	}
 	return f.Name()
 }
 
@@ -56,7 +64,7 @@ func TestOpenSQLiteIndex(t *testing.T) {
 	}
 	defer idx.Close()
 
-	compounds, err := idx.QueryBySmiles("O", false)
+	compounds, _, err := idx.QueryBySmiles(context.Background(), "O", QueryOptions{})
 	if err != nil {
 		t.Fatalf("QueryBySmiles returned error: %v", err)
 	}
@@ -89,12 +97,78 @@ func TestOpenSQLiteIndex_MissingTable(t *testing.T) {
 	}
 }
 
+// TestVerify_EmptyDB verifies that a database without compounds isn't accepted, e.g. for
+// a hot reload, while the test data is.
+func TestVerify_EmptyDB(t *testing.T) {
+	idx, err := OpenSQLiteIndex(createTempDB(t))
+	if err != nil {
+		t.Fatalf("OpenSQLiteIndex failed: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.Verify(); err == nil {
+		t.Error("expected error verifying an empty DB, got nil")
+	}
+
+	loaded := loadTestIndex(t)
+	defer loaded.Close()
+	if err := loaded.Verify(); err != nil {
+		t.Errorf("Verify failed on the test data: %v", err)
+	}
+}
+
+// TestMetadata verifies the dataset metadata of the loaded indexes and of a database
+// built before metadata was recorded.
+func TestMetadata(t *testing.T) {
+	idx, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer idx.Close()
+
+	m := idx.Metadata()
+	if m.Dataset != "unittest_data" || m.RowCount != 3 || m.SchemaVersion != SchemaVersion || len(m.SourceSHA256) != 64 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	memory, err := LoadMemoryIndex(testCSV)
+	if err != nil {
+		t.Fatalf("LoadMemoryIndex failed: %v", err)
+	}
+	want := *m
+	want.BuiltAt = memory.Metadata().BuiltAt
+	if diff := cmp.Diff(&want, memory.Metadata()); diff != "" {
+		t.Errorf("memory index metadata mismatch (-sqlite +memory):\n%s", diff)
+	}
+
+	// Newer databases aren't served
+	if _, err := idx.DB().Exec(InsertMetadataSQL, "schema_version", SchemaVersion+1); err != nil {
+		t.Fatalf("failed to bump schema version: %v", err)
+	}
+	newer, err := newIndex(idx.DB())
+	if err != nil {
+		t.Fatalf("newIndex failed: %v", err)
+	}
+	if err := newer.Verify(); err == nil {
+		t.Error("expected error verifying a newer schema version, got nil")
+	}
+
+	// Databases without metadata open with empty metadata
+	empty, err := OpenSQLiteIndex(createTempDB(t))
+	if err != nil {
+		t.Fatalf("OpenSQLiteIndex failed: %v", err)
+	}
+	defer empty.Close()
+	if diff := cmp.Diff(&Metadata{}, empty.Metadata()); diff != "" {
+		t.Errorf("expected empty metadata (-want +got):\n%s", diff)
+	}
+}
+
 func TestLoadCSVToMemory(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
 	// Sanity-check: at least one compound is queryable
-	compounds, err := idx.QueryBySmiles("O", false)
+	compounds, _, err := idx.QueryBySmiles(context.Background(), "O", QueryOptions{})
 	if err != nil {
 		t.Fatalf("QueryBySmiles returned error: %v", err)
 	}
@@ -125,7 +199,7 @@ func TestQueryByInChIKey_Hit(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByInChIKey("MYFAKEINCHIKEY-ISRIGHTHER-E", false)
+	compounds, _, err := idx.QueryByInChIKey(context.Background(), "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -141,7 +215,7 @@ func TestQueryByInChIKey_Miss(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByInChIKey("ZZZZZZZZZZZZZZ-ZZZZZZZZZZ-Z", false)
+	compounds, _, err := idx.QueryByInChIKey(context.Background(), "ZZZZZZZZZZZZZZ-ZZZZZZZZZZ-Z", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -157,7 +231,7 @@ func TestQueryByFirstBlock_OrderedByScore(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByFirstBlock("MYFAKEINCHIKEY", false)
+	compounds, _, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -176,7 +250,7 @@ func TestQueryByFirstBlock_Miss(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByFirstBlock("DOESNOTEXIST00", false)
+	compounds, _, err := idx.QueryByFirstBlock(context.Background(), "DOESNOTEXIST00", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -189,7 +263,7 @@ func TestQueryByFirstBlock_TopHitOnly(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByFirstBlock("MYFAKEINCHIKEY", true)
+	compounds, _, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{TopHitOnly: true})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -205,7 +279,7 @@ func TestQueryByInChI_Hit(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByInChI("InChI=1S/CH4/h1H4", false)
+	compounds, _, err := idx.QueryByInChI(context.Background(), "InChI=1S/CH4/h1H4", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -221,7 +295,7 @@ func TestQueryByInChI_Miss(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByInChI("InChI=1S/NOTHING", false)
+	compounds, _, err := idx.QueryByInChI(context.Background(), "InChI=1S/NOTHING", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -234,7 +308,7 @@ func TestQueryBySmiles_Hit(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryBySmiles("C=O", false)
+	compounds, _, err := idx.QueryBySmiles(context.Background(), "C=O", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -250,7 +324,7 @@ func TestQueryBySmiles_Miss(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryBySmiles("CC(O)=O", false)
+	compounds, _, err := idx.QueryBySmiles(context.Background(), "CC(O)=O", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -263,7 +337,7 @@ func TestQueryByFormula_Hit(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByFormula("CH2O", false)
+	compounds, _, err := idx.QueryByFormula(context.Background(), "CH2O", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -279,7 +353,86 @@ func TestQueryByFormula_Miss(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByFormula("C99H99", false)
+	compounds, _, err := idx.QueryByFormula(context.Background(), "C99H99", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 0 {
+		t.Errorf("expected 0 compounds, got %d", len(compounds))
+	}
+}
+
+func TestQueryByMass_Hit(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	compounds, _, err := idx.QueryByMass(context.Background(), 30.0001, 0.001, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 {
+		t.Fatalf("expected 1 compound, got %d", len(compounds))
+	}
+	if compounds[0].CompoundName != "Formaldehyde" {
+		t.Errorf("expected Formaldehyde, got %s", compounds[0].CompoundName)
+	}
+}
+
+// TestQueryByMass_OrderedByScore verifies that a window covering Water (100)
+// and Methane (99) returns both, Methane first by score.
+func TestQueryByMass_OrderedByScore(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	compounds, _, err := idx.QueryByMass(context.Background(), 99.9, 1, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Methane", "Water"}
+	var got []string
+	for _, c := range compounds {
+		got = append(got, c.CompoundName)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ordering mismatch (-want +got):\n%s", diff)
+	}
+
+	top, _, err := idx.QueryByMass(context.Background(), 99.9, 1, QueryOptions{TopHitOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(top) != 1 || top[0].CompoundName != "Methane" {
+		t.Errorf("expected top hit Methane, got %v", top)
+	}
+}
+
+func TestQueryByMass_Miss(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	compounds, _, err := idx.QueryByMass(context.Background(), 500, 0.01, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 0 {
+		t.Errorf("expected 0 compounds, got %d", len(compounds))
+	}
+}
+
+func TestQueryByName(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	compounds, _, err := idx.QueryByName(context.Background(), "Water", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 || compounds[0].Identifier != "1" {
+		t.Fatalf("expected Water, got %v", compounds)
+	}
+
+	// Exact lookups are case-sensitive
+	compounds, _, err = idx.QueryByName(context.Background(), "water", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -288,6 +441,1078 @@ func TestQueryByFormula_Miss(t *testing.T) {
 	}
 }
 
+func TestQueryByNameCaseInsensitive(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	tests := []struct {
+		name      string
+		wantCount int
+	}{
+		{"fORMALDEHYDE", 1},
+		{"formaldehyde", 1},
+		{"formal", 0},      // partial tokens are not whole-name matches
+		{"\"methane\"", 0}, // quotes are escaped, not FTS syntax
+		{"--", 0},          // no tokens at all
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			compounds, _, err := idx.QueryByNameCaseInsensitive(context.Background(), tc.name, QueryOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(compounds) != tc.wantCount {
+				t.Errorf("expected %d compounds, got %d", tc.wantCount, len(compounds))
+			}
+		})
+	}
+}
+
+func TestQueryByInChIKeyIgnoringProtonation(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	// Only Water shares the first two blocks, Methane only the first block
+	compounds, total, err := idx.QueryByInChIKeyIgnoringProtonation(context.Background(), "MYFAKEINCHIKEY-ISRIGHTHER-N", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 || total != 1 || compounds[0].Identifier != "1" {
+		t.Errorf("expected only Water, got %d compounds (total %d)", len(compounds), total)
+	}
+
+	hits, _, err := idx.QueryByInChIKeysIgnoringProtonation(context.Background(), []string{"MYFAKEINCHIKEY-ISRIGHTHER-N", "MYFAKEINCHIKEY-NOTINTHEDB-N"}, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits) != 1 || len(hits["MYFAKEINCHIKEY-ISRIGHTHER"]) != 1 {
+		t.Errorf("expected a single hit keyed by the first two blocks, got %v", hits)
+	}
+}
+
+func TestSkeletonInChI(t *testing.T) {
+	tests := []struct {
+		inchi string
+		want  string
+	}{
+		{"InChI=1S/H2O/h1H2", "InChI=1S/H2O/h1H2"},
+		// L-alanine
+		{"InChI=1S/C3H7NO2/c1-2(4)3(5)6/h2H,4H2,1H3,(H,5,6)/t2-/m0/s1", "InChI=1S/C3H7NO2/c1-2(4)3(5)6/h2H,4H2,1H3,(H,5,6)"},
+		// (E)-but-2-ene
+		{"InChI=1S/C4H8/c1-3-4-2/h3-4H,1-2H3/b4-3+", "InChI=1S/C4H8/c1-3-4-2/h3-4H,1-2H3"},
+		// Isotopic layer and its stereo sublayers
+		{"InChI=1S/C2H6O/c1-2-3/h3H,2H2,1H3/i1D/t2-/m1/s1", "InChI=1S/C2H6O/c1-2-3/h3H,2H2,1H3"},
+		// Charge and protonation layers are kept
+		{"InChI=1S/C2H4O2/c1-2(3)4/h1H3,(H,3,4)/p-1", "InChI=1S/C2H4O2/c1-2(3)4/h1H3,(H,3,4)/p-1"},
+		{"InChI=1S/H2O", "InChI=1S/H2O"},
+	}
+	for _, tc := range tests {
+		if got := SkeletonInChI(tc.inchi); got != tc.want {
+			t.Errorf("SkeletonInChI(%q) = %q, want %q", tc.inchi, got, tc.want)
+		}
+	}
+}
+
+func TestQueryBySkeletonInChI(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	compounds, total, err := idx.QueryBySkeletonInChI(context.Background(), "InChI=1S/CH2O/c1-2/h1H2/i1+1", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 || total != 1 || compounds[0].Identifier != "3" {
+		t.Errorf("expected only Formaldehyde, got %d compounds (total %d)", len(compounds), total)
+	}
+
+	hits, _, err := idx.QueryBySkeletonInChIs(context.Background(), []string{"InChI=1S/CH4/h1H4/i1D"}, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits["InChI=1S/CH4/h1H4"]) != 1 {
+		t.Errorf("expected a Methane hit keyed by its skeleton, got %v", hits)
+	}
+}
+
+func TestQueryBatch(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	hits, _, err := idx.QueryByInChIKeys(context.Background(), []string{"MYFAKEINCHIKEY-ANOTHERONE-E", "MISSINGMISSING-MISSINGMIS-N", "MYFAKEINCHIKEY-ANOTHERONE-E"}, QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits) != 1 || len(hits["MYFAKEINCHIKEY-ANOTHERONE-E"]) != 1 {
+		t.Errorf("expected a single hit for the Methane key, got %v", hits)
+	}
+
+	// Both Water and Methane share the first block, ordered by score like QueryByFirstBlock
+	for _, topHitOnly := range []bool{false, true} {
+		want, _, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{TopHitOnly: topHitOnly})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		hits, _, err := idx.QueryByFirstBlocks(context.Background(), []string{"MYFAKEINCHIKEY", "FAKEFORMALDEHY"}, QueryOptions{TopHitOnly: topHitOnly})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(want, hits["MYFAKEINCHIKEY"]); diff != "" {
+			t.Errorf("topHitOnly=%v: batch mismatch (-want +got):\n%s", topHitOnly, diff)
+		}
+		if len(hits["FAKEFORMALDEHY"]) != 1 {
+			t.Errorf("topHitOnly=%v: expected 1 Formaldehyde hit, got %d", topHitOnly, len(hits["FAKEFORMALDEHY"]))
+		}
+	}
+
+	// Spread the known IDs over several chunks
+	ids := make([]string, 0, 3*batchChunkSize)
+	for i := range 3 * batchChunkSize {
+		ids = append(ids, fmt.Sprintf("%d", 1000+i))
+	}
+	ids = append(ids, "1", "2", "3")
+	hits, _, err = idx.QueryByPubChemIDs(context.Background(), ids, QueryOptions{TopHitOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits) != 3 {
+		t.Errorf("expected hits for 3 IDs, got %d", len(hits))
+	}
+
+	hits, _, err = idx.QueryByInChIs(context.Background(), []string{"InChI=1S/CH4/h1H4"}, QueryOptions{TopHitOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits["InChI=1S/CH4/h1H4"]) != 1 {
+		t.Errorf("expected 1 hit for the Methane InChI, got %v", hits)
+	}
+}
+
+func TestQueryRanking(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	// Water (CID 1) and Methane (CID 2, higher counts) share the first block
+	tests := []struct {
+		rank    Ranking
+		wantIDs []string
+	}{
+		{Ranking{}, []string{"2", "1"}},
+		{RankByLiterature, []string{"2", "1"}},
+		{RankByPatent, []string{"2", "1"}},
+		{RankByCID, []string{"1", "2"}},
+		{CustomRanking(0, 0), []string{"1", "2"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.rank.String(), func(t *testing.T) {
+			compounds, _, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{Rank: tc.rank})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for _, c := range compounds {
+				ids = append(ids, c.Identifier)
+			}
+			if diff := cmp.Diff(tc.wantIDs, ids); diff != "" {
+				t.Errorf("order mismatch (-want +got):\n%s", diff)
+			}
+
+			// Batch lookups rank the same way
+			hits, _, err := idx.QueryByFirstBlocks(context.Background(), []string{"MYFAKEINCHIKEY"}, QueryOptions{Rank: tc.rank, TopHitOnly: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hits["MYFAKEINCHIKEY"][0].Identifier; got != tc.wantIDs[0] {
+				t.Errorf("expected batch top hit %s, got %s", tc.wantIDs[0], got)
+			}
+		})
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	// Methane (CID 2) ranks before Water (CID 1) on the shared first block
+	all, _, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    QueryOptions
+		wantIDs []string
+	}{
+		{"all hits", QueryOptions{}, []string{"2", "1"}},
+		{"max hits", QueryOptions{MaxHits: 1}, []string{"2"}},
+		{"offset", QueryOptions{Offset: 1}, []string{"1"}},
+		{"top hit after offset", QueryOptions{TopHitOnly: true, Offset: 1}, []string{"1"}},
+		{"past the end", QueryOptions{Offset: 2}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			compounds, total, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for _, c := range compounds {
+				ids = append(ids, c.Identifier)
+			}
+			if diff := cmp.Diff(tc.wantIDs, ids); diff != "" {
+				t.Errorf("page mismatch (-want +got):\n%s", diff)
+			}
+			// The total is only known from the rows of a non-empty page
+			if len(ids) > 0 && total != 2 {
+				t.Errorf("expected a total of 2 hits, got %d", total)
+			}
+
+			hits, totals, err := idx.QueryByFirstBlocks(context.Background(), []string{"MYFAKEINCHIKEY"}, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(compounds, hits["MYFAKEINCHIKEY"]); diff != "" {
+				t.Errorf("batch page mismatch (-want +got):\n%s", diff)
+			}
+			if len(ids) > 0 && totals["MYFAKEINCHIKEY"] != 2 {
+				t.Errorf("expected a batch total of 2 hits, got %d", totals["MYFAKEINCHIKEY"])
+			}
+
+			if diff := cmp.Diff(compounds, tc.opts.Paginate(all)); diff != "" {
+				t.Errorf("Paginate mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRankingString(t *testing.T) {
+	for rank, want := range map[Ranking]string{
+		{}:                      "score",
+		RankByPatent:            "patent",
+		CustomRanking(0.2, 0.8): "custom:0.2,0.8",
+		CustomRanking(1, 0):     "custom:1,0",
+	} {
+		if got := rank.String(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestQueryBySynonym(t *testing.T) {
+	idx, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer idx.Close()
+
+	// CID 4 is not in the index, InsertSynonymSQL drops it
+	for _, s := range [][2]string{{"1", "Oxidane"}, {"2", "Marsh gas"}, {"3", "Oxomethane"}, {"4", "Oxidane"}} {
+		if _, err := idx.DB().Exec(InsertSynonymSQL, s[0], s[1]); err != nil {
+			t.Fatalf("failed to insert synonym: %v", err)
+		}
+	}
+
+	compounds, _, err := idx.QueryBySynonym(context.Background(), "Oxidane", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 {
+		t.Fatalf("expected 1 compound, got %d", len(compounds))
+	}
+	if compounds[0].Identifier != "1" || compounds[0].Synonym != "Oxidane" {
+		t.Errorf("expected Water with synonym Oxidane, got %+v", compounds[0])
+	}
+
+	compounds, _, err = idx.QueryBySynonym(context.Background(), "oxidane", QueryOptions{TopHitOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 0 {
+		t.Errorf("expected synonyms to match exactly, got %d compounds", len(compounds))
+	}
+}
+
+func TestNormalizeFormula(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"H2O", "H2O"},
+		{"OH2", "H2O"},
+		{"C6 H12 O6", "C6H12O6"},
+		{"O6H12C6", "C6H12O6"},
+		{"C6H12O6+", "C6H12O6+"},
+		{"C2H3O2-", "C2H3O2-"},
+		{"Ca+2", "Ca+2"},
+		{"Ca++", "Ca+2"},
+		{"SO4--", "O4S-2"},
+		{"(CH3)2CO", "C3H6O"},
+		{"NaCl", "ClNa"},
+		{"h2o", "H2O"},
+		{"co2", "CO2"},
+		{"c6h12o6", "C6H12O6"},
+		{"C2[2H]6O", "C2D6O"},
+		{"H4[13C]", "[13C]H4"},
+		{"C[13C]H6", "C[13C]H6"},
+	}
+	for _, tc := range tests {
+		got, err := NormalizeFormula(tc.formula)
+		if err != nil {
+			t.Errorf("NormalizeFormula(%q) unexpected error: %v", tc.formula, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("NormalizeFormula(%q) = %q, want %q", tc.formula, got, tc.want)
+		}
+	}
+
+	invalid := []string{
+		"", "Unknown", "Formaldehyde", "Xy2", "C6(H12", "[C]H4", "+", "12345a", "c1ccccc1", "nc1ccccc1", "ch3cooh2",
+		// Count ranges of formula: queries rather than charges
+		"SO4-2", "H10-14", "O2-4", "C6-8", "Ca+5",
+	}
+	for _, formula := range invalid {
+		if got, err := NormalizeFormula(formula); err == nil {
+			t.Errorf("NormalizeFormula(%q) = %q, expected an error", formula, got)
+		}
+	}
+}
+
+func TestParseFormulaRange(t *testing.T) {
+	got, err := ParseFormulaRange("C6-8 H10-14 O2-4 N0 S0 Cl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ElementRange{
+		{"C", 6, 8}, {"H", 10, 14}, {"O", 2, 4}, {"N", 0, 0}, {"S", 0, 0}, {"Cl", 1, 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseFormulaRange mismatch (-want +got):\n%s", diff)
+	}
+
+	if got, err := ParseFormulaRange("C1-2H4"); err != nil || len(got) != 2 {
+		t.Errorf("expected the compact form to parse, got %+v, %v", got, err)
+	}
+
+	for _, s := range []string{"", "C8-6", "C-4", "C1C2", "Na1", "c6", "C6-8x"} {
+		if got, err := ParseFormulaRange(s); err == nil {
+			t.Errorf("ParseFormulaRange(%q) = %+v, expected an error", s, got)
+		}
+	}
+}
+
+func TestQueryByFormulaRange(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	tests := []struct {
+		formula string
+		wantIDs []string
+	}{
+		{"C1 H2-4", []string{"2", "3"}},
+		{"C0-1 O0", []string{"2"}},
+		{"C0 H2", []string{"1"}},
+		{"C2-6", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.formula, func(t *testing.T) {
+			ranges, err := ParseFormulaRange(tc.formula)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %v", err)
+			}
+			compounds, total, err := idx.QueryByFormulaRange(context.Background(), ranges, QueryOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for _, c := range compounds {
+				ids = append(ids, c.Identifier)
+			}
+			if diff := cmp.Diff(tc.wantIDs, ids); diff != "" {
+				t.Errorf("QueryByFormulaRange mismatch (-want +got):\n%s", diff)
+			}
+			if total != len(tc.wantIDs) {
+				t.Errorf("expected total %d, got %d", len(tc.wantIDs), total)
+			}
+		})
+	}
+}
+
+func TestParseXref(t *testing.T) {
+	tests := []struct {
+		id         string
+		wantSource string
+		wantXref   string
+		wantErr    error
+	}{
+		{"7732-18-5", XrefCAS, "7732-18-5", nil},
+		{"50-00-0", XrefCAS, "50-00-0", nil},
+		{"7732-18-4", XrefCAS, "", ErrCASChecksum},
+		{"HMDB0002111", XrefHMDB, "HMDB0002111", nil},
+		{"hmdb02111", XrefHMDB, "HMDB0002111", nil},
+		{"C00001", XrefKEGG, "C00001", nil},
+		{"CHEBI:15377", XrefChEBI, "CHEBI:15377", nil},
+		{"ChEBI:15377", XrefChEBI, "CHEBI:15377", nil},
+		{"HMDB002111", "", "", nil},
+		{"C0001", "", "", nil},
+		{"CHEBI:", "", "", nil},
+		{"12345", "", "", nil},
+	}
+	for _, tc := range tests {
+		source, xref, err := ParseXref(tc.id)
+		if source != tc.wantSource || xref != tc.wantXref || !errors.Is(err, tc.wantErr) {
+			t.Errorf("ParseXref(%q) = %q, %q, %v, want %q, %q, %v", tc.id, source, xref, err, tc.wantSource, tc.wantXref, tc.wantErr)
+		}
+	}
+}
+
+func TestQueryByXref(t *testing.T) {
+	idx, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer idx.Close()
+
+	for _, x := range [][3]string{{"1", XrefCAS, "7732-18-5"}, {"1", XrefChEBI, "CHEBI:15377"}, {"1", XrefKEGG, "C00001"}, {"2", XrefKEGG, "C01438"}} {
+		if _, err := idx.DB().Exec(InsertXrefSQL, x[0], x[1], x[2]); err != nil {
+			t.Fatalf("failed to insert xref: %v", err)
+		}
+	}
+
+	compounds, total, err := idx.QueryByXref(context.Background(), XrefCAS, "7732-18-5", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 1 || total != 1 || compounds[0].Identifier != "1" {
+		t.Fatalf("expected Water, got %+v (total %d)", compounds, total)
+	}
+
+	// The source must match too
+	compounds, _, err = idx.QueryByXref(context.Background(), XrefHMDB, "C00001", QueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(compounds) != 0 {
+		t.Errorf("expected no compound for a KEGG ID looked up as HMDB, got %d", len(compounds))
+	}
+
+	water, _, _ := idx.QueryByPubChemID(context.Background(), "1", QueryOptions{})
+	methane, _, _ := idx.QueryByPubChemID(context.Background(), "2", QueryOptions{})
+	formaldehyde, _, _ := idx.QueryByPubChemID(context.Background(), "3", QueryOptions{})
+	if err := idx.AttachXrefs(context.Background(), []*Compound{water[0], methane[0], formaldehyde[0], water[0]}); err != nil {
+		t.Fatalf("AttachXrefs failed: %v", err)
+	}
+	want := map[string][]string{XrefCAS: {"7732-18-5"}, XrefChEBI: {"CHEBI:15377"}, XrefKEGG: {"C00001"}}
+	if diff := cmp.Diff(want, water[0].Xrefs); diff != "" {
+		t.Errorf("water xrefs mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string][]string{XrefKEGG: {"C01438"}}, methane[0].Xrefs); diff != "" {
+		t.Errorf("methane xrefs mismatch (-want +got):\n%s", diff)
+	}
+	if formaldehyde[0].Xrefs != nil {
+		t.Errorf("expected no xrefs for formaldehyde, got %v", formaldehyde[0].Xrefs)
+	}
+}
+
+func TestQuerySuggestions(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	tests := []struct {
+		prefix string
+		want   []Suggestion
+	}{
+		{"meth", []Suggestion{{Value: "Methane", Type: "compound_name", Identifier: "2", CompoundName: "Methane"}}},
+		// Both compounds share the first block, the best scored one (Methane) represents it
+		{"myfake", []Suggestion{{Value: "MYFAKEINCHIKEY", Type: "inchikey_first_block", Identifier: "2", CompoundName: "Methane"}}},
+		{"3", []Suggestion{{Value: "3", Type: "pubchem_cid", Identifier: "3", CompoundName: "Formaldehyde"}}},
+		// Short CID and first block prefixes only suggest the exact value
+		{"myfa", nil},
+		{"zzz", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.prefix, func(t *testing.T) {
+			got, err := idx.QuerySuggestions(context.Background(), tc.prefix, 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var values []Suggestion
+			for _, s := range got {
+				values = append(values, Suggestion{Value: s.Value, Type: s.Type, Identifier: s.Identifier, CompoundName: s.CompoundName})
+			}
+			if diff := cmp.Diff(tc.want, values); diff != "" {
+				t.Errorf("suggestions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestQuerySuggestions_Limit verifies the limit and the score reported for a suggestion
+func TestQuerySuggestions_Limit(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	// "fakef" matches the FAKEFORMALDEHY first block (score 0.7*5 + 0.3*1) and the MYFAKE... one does not
+	got, err := idx.QuerySuggestions(context.Background(), "fakef", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].Value != "FAKEFORMALDEHY" {
+		t.Errorf("expected FAKEFORMALDEHY, got %+v", got)
+	}
+	if got[0].Score < 3.79 || got[0].Score > 3.81 {
+		t.Errorf("expected score 3.8, got %v", got[0].Score)
+	}
+}
+
+// TestMemoryIndexMatchesSQLite runs the same lookups on both CompoundIndex implementations
+func TestMemoryIndexMatchesSQLite(t *testing.T) {
+	sqlite, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer sqlite.Close()
+	memory, err := LoadMemoryIndex(testCSV)
+	if err != nil {
+		t.Fatalf("LoadMemoryIndex failed: %v", err)
+	}
+
+	for _, s := range [][2]string{{"1", "Oxidane"}, {"2", "Marsh gas"}, {"3", "Oxidane"}, {"4", "Oxidane"}} {
+		if _, err := sqlite.DB().Exec(InsertSynonymSQL, s[0], s[1]); err != nil {
+			t.Fatalf("failed to insert synonym: %v", err)
+		}
+		memory.AddSynonym(s[0], s[1])
+	}
+	for _, x := range [][3]string{{"1", XrefCAS, "7732-18-5"}, {"1", XrefKEGG, "C00001"}, {"2", XrefKEGG, "C01438"}} {
+		if _, err := sqlite.DB().Exec(InsertXrefSQL, x[0], x[1], x[2]); err != nil {
+			t.Fatalf("failed to insert xref: %v", err)
+		}
+		memory.AddXref(x[0], x[1], x[2])
+	}
+
+	type result struct {
+		Compounds []*Compound
+		Total     int
+	}
+	lookups := map[string]func(idx CompoundIndex, opts QueryOptions) (result, error){
+		"pubchem id": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByPubChemID(context.Background(), "2", opts)
+			return result{c, n}, err
+		},
+		"first block": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", opts)
+			return result{c, n}, err
+		},
+		"protonation": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByInChIKeyIgnoringProtonation(context.Background(), "MYFAKEINCHIKEY-ISRIGHTHER-X", opts)
+			return result{c, n}, err
+		},
+		"skeleton inchi": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryBySkeletonInChI(context.Background(), "InChI=1S/CH2O/c1-2/h1H2/i1+1", opts)
+			return result{c, n}, err
+		},
+		"formula range": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByFormulaRange(context.Background(), []ElementRange{{"C", 0, 1}, {"H", 2, 4}}, opts)
+			return result{c, n}, err
+		},
+		"mass": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByMass(context.Background(), 99.5, 1, opts)
+			return result{c, n}, err
+		},
+		"name nocase": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByNameCaseInsensitive(context.Background(), "METHANE", opts)
+			return result{c, n}, err
+		},
+		"synonym": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryBySynonym(context.Background(), "Oxidane", opts)
+			return result{c, n}, err
+		},
+		"xref": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			c, n, err := idx.QueryByXref(context.Background(), XrefKEGG, "C01438", opts)
+			if err == nil {
+				err = idx.AttachXrefs(context.Background(), c)
+			}
+			return result{c, n}, err
+		},
+		"batch inchikeys": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			hits, totals, err := idx.QueryByInChIKeys(context.Background(), []string{"MYFAKEINCHIKEY-ISRIGHTHER-E", "MYFAKEINCHIKEY-ANOTHERONE-E", "NOPE"}, opts)
+			return result{append(hits["MYFAKEINCHIKEY-ISRIGHTHER-E"], hits["NOPE"]...), totals["MYFAKEINCHIKEY-ANOTHERONE-E"]}, err
+		},
+		"batch first blocks": func(idx CompoundIndex, opts QueryOptions) (result, error) {
+			hits, totals, err := idx.QueryByFirstBlocks(context.Background(), []string{"MYFAKEINCHIKEY"}, opts)
+			return result{hits["MYFAKEINCHIKEY"], totals["MYFAKEINCHIKEY"]}, err
+		},
+	}
+	options := []QueryOptions{
+		{},
+		{TopHitOnly: true},
+		{Rank: RankByCID},
+		{Rank: CustomRanking(0, 1), MaxHits: 1, Offset: 1},
+		{Offset: 5},
+		{Fields: FieldIdentifier | FieldInChIKey},
+		{Fields: FieldSmiles | FieldCharge, TopHitOnly: true},
+	}
+
+	for name, lookup := range lookups {
+		for _, opts := range options {
+			want, err := lookup(sqlite, opts)
+			if err != nil {
+				t.Fatalf("%s: SQLite lookup failed: %v", name, err)
+			}
+			got, err := lookup(memory, opts)
+			if err != nil {
+				t.Fatalf("%s: memory lookup failed: %v", name, err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("%s %+v: memory index mismatch (-sqlite +memory):\n%s", name, opts, diff)
+			}
+		}
+	}
+
+	for _, prefix := range []string{"meth", "MYFAKE", "MYFA", "123", "3"} {
+		want, err := sqlite.QuerySuggestions(context.Background(), prefix, 10)
+		if err != nil {
+			t.Fatalf("SQLite suggestions failed: %v", err)
+		}
+		got, err := memory.QuerySuggestions(context.Background(), prefix, 10)
+		if err != nil {
+			t.Fatalf("memory suggestions failed: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("suggestions %q: memory index mismatch (-sqlite +memory):\n%s", prefix, diff)
+		}
+	}
+}
+
+// TestIndexHolderSwap verifies that a swap serves new requests from the new index at once,
+// and only closes the previous index after the requests running on it are done.
+func TestIndexHolderSwap(t *testing.T) {
+	previous := loadTestIndex(t)
+	next, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	indexes := NewIndexHolder(previous)
+	defer indexes.Close()
+
+	inFlight, release := indexes.Acquire()
+	if inFlight != CompoundIndex(previous) {
+		t.Fatal("Acquire didn't return the initial index")
+	}
+
+	swapped := make(chan error, 1)
+	go func() { swapped <- indexes.Swap(next) }()
+
+	// The new index is served while the swap waits on the request in flight
+	for {
+		current, done := indexes.Acquire()
+		done()
+		if current == CompoundIndex(next) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-swapped:
+		t.Fatalf("Swap returned before the request in flight was done: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, _, err := inFlight.QueryBySmiles(context.Background(), "O", QueryOptions{}); err != nil {
+		t.Fatalf("request in flight failed during the swap: %v", err)
+	}
+
+	release()
+	release() // releasing twice is harmless
+	if err := <-swapped; err != nil {
+		t.Fatalf("Swap failed: %v", err)
+	}
+	if _, _, err := previous.QueryBySmiles(context.Background(), "O", QueryOptions{}); err == nil {
+		t.Error("expected the previous index to be closed after the swap")
+	}
+}
+
+// TestQuery_CanceledContext verifies that lookups of both indexes stop with the context's
+// error once it is done.
+func TestQuery_CanceledContext(t *testing.T) {
+	memory, err := LoadMemoryIndex(testCSV)
+	if err != nil {
+		t.Fatalf("LoadMemoryIndex failed: %v", err)
+	}
+	sqlite := loadTestIndex(t)
+	defer sqlite.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, idx := range map[string]CompoundIndex{"sqlite": sqlite, "memory": memory} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := idx.QueryBySmiles(ctx, "O", QueryOptions{}); !errors.Is(err, context.Canceled) {
+				t.Errorf("QueryBySmiles: expected context.Canceled, got %v", err)
+			}
+			if _, _, err := idx.QueryByInChIKeys(ctx, []string{"MYFAKEINCHIKEY-ISRIGHTHER-E"}, QueryOptions{}); !errors.Is(err, context.Canceled) {
+				t.Errorf("QueryByInChIKeys: expected context.Canceled, got %v", err)
+			}
+			if _, err := idx.QuerySuggestions(ctx, "wat", 10); !errors.Is(err, context.Canceled) {
+				t.Errorf("QuerySuggestions: expected context.Canceled, got %v", err)
+			}
+		})
+	}
+}
+
+// TestCachedIndex verifies that repeated lookups are answered from the cache, that batch
+// lookups only query the values they miss, and that cached hits are copies.
+func TestCachedIndex(t *testing.T) {
+	ctx := context.Background()
+	idx, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer idx.Close()
+	var stats CacheStats
+	cached := NewCachedIndex(idx, 10, &stats)
+	expectStats := func(hits, misses int64) {
+		t.Helper()
+		if stats.Hits.Load() != hits || stats.Misses.Load() != misses {
+			t.Errorf("expected %d hits and %d misses, got %d and %d", hits, misses, stats.Hits.Load(), stats.Misses.Load())
+		}
+	}
+
+	want, wantTotal, _ := idx.QueryByInChIKey(ctx, "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{})
+	got, total, err := cached.QueryByInChIKey(ctx, "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{})
+	if err != nil {
+		t.Fatalf("QueryByInChIKey failed: %v", err)
+	}
+	expectStats(0, 1)
+
+	// Callers annotate their hits, the cache keeps its own copies
+	got[0].Adduct = "[M+H]+"
+	got, total, _ = cached.QueryByInChIKey(ctx, "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{})
+	expectStats(1, 1)
+	if diff := cmp.Diff(want, got); diff != "" || total != wantTotal {
+		t.Errorf("cached lookup mismatch (-want +got):\n%s", diff)
+	}
+
+	// Other options are another lookup
+	cached.QueryByInChIKey(ctx, "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{TopHitOnly: true})
+	expectStats(1, 2)
+
+	// The batch reuses the single lookup and only queries the rest
+	keys := []string{"MYFAKEINCHIKEY-ISRIGHTHER-E", "MYFAKEINCHIKEY-ANOTHERONE-E", "AAAAAAAAAAAAAA-BBBBBBBBBB-C"}
+	wantHits, wantTotals, _ := idx.QueryByInChIKeys(ctx, keys, QueryOptions{})
+	for range 2 {
+		hits, totals, err := cached.QueryByInChIKeys(ctx, keys, QueryOptions{})
+		if err != nil {
+			t.Fatalf("QueryByInChIKeys failed: %v", err)
+		}
+		if diff := cmp.Diff(wantHits, hits); diff != "" {
+			t.Errorf("cached batch mismatch (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(wantTotals, totals); diff != "" {
+			t.Errorf("cached batch totals mismatch (-want +got):\n%s", diff)
+		}
+	}
+	expectStats(5, 4)
+
+	// Batches keyed by the first two blocks cache those
+	protonated := []string{"MYFAKEINCHIKEY-ANOTHERONE-N", "SHORT"}
+	wantHits, _, _ = idx.QueryByInChIKeysIgnoringProtonation(ctx, protonated, QueryOptions{})
+	for range 2 {
+		hits, _, _ := cached.QueryByInChIKeysIgnoringProtonation(ctx, protonated, QueryOptions{})
+		if diff := cmp.Diff(wantHits, hits); diff != "" {
+			t.Errorf("cached protonation batch mismatch (-want +got):\n%s", diff)
+		}
+	}
+	expectStats(6, 5)
+
+	// Hits come from the cache, not the database
+	if _, err := idx.DB().Exec(`DELETE FROM compounds`); err != nil {
+		t.Fatalf("failed to delete compounds: %v", err)
+	}
+	if got, _, _ := cached.QueryByInChIKey(ctx, "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{}); len(got) != 1 {
+		t.Errorf("expected the cached hit, got %d compounds", len(got))
+	}
+
+	// The least recently used lookup is evicted
+	small := NewCachedIndex(idx, 1, &CacheStats{})
+	small.QueryBySmiles(ctx, "O", QueryOptions{})
+	small.QueryBySmiles(ctx, "C", QueryOptions{})
+	small.QueryBySmiles(ctx, "O", QueryOptions{})
+	if small.stats.Misses.Load() != 3 {
+		t.Errorf("expected 3 misses with a single entry cache, got %d", small.stats.Misses.Load())
+	}
+}
+
+// TestCachedIndex_MaxHits verifies that pages of more than maxCachedHits hits are not cached
+func TestCachedIndex_MaxHits(t *testing.T) {
+	ctx := context.Background()
+	idx, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer idx.Close()
+	defer func(n int) { maxCachedHits = n }(maxCachedHits)
+	maxCachedHits = 1
+
+	var stats CacheStats
+	cached := NewCachedIndex(idx, 10, &stats)
+	for range 2 {
+		// Water and methane share the first block
+		if got, _, _ := cached.QueryByFirstBlock(ctx, "MYFAKEINCHIKEY", QueryOptions{}); len(got) != 2 {
+			t.Fatalf("expected 2 hits, got %d", len(got))
+		}
+		cached.QueryByPubChemID(ctx, "1", QueryOptions{})
+	}
+	if stats.Hits.Load() != 1 || stats.Misses.Load() != 3 {
+		t.Errorf("expected only the single hit lookup to be cached, got %d hits and %d misses", stats.Hits.Load(), stats.Misses.Load())
+	}
+}
+
+// TestIdentifierTies verifies that all indexes break ties on rank alike: lowest CID first,
+// then identifiers that aren't CIDs
+func TestIdentifierTies(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "library.csv")
+	content := `Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName
+STD-7,1,1,CH4,C,InChI=1S/CH4/h1H4,VNWKTOKETHGBQD-UHFFFAOYSA-N,16.03,Methane standard
+12,1,1,CH4,C,InChI=1S/CH4/h1H4,VNWKTOKETHGBQD-UHFFFAOYSA-N,16.03,Methane
+5,1,1,CH4,C,InChI=1S/CH4/h1H4,VNWKTOKETHGBQD-UHFFFAOYSA-N,16.03,Methane
+`
+	if err := os.WriteFile(csvPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+
+	sqlite, err := LoadCSVToPrivateMemory(csvPath)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer sqlite.Close()
+	memory, err := LoadMemoryIndex(csvPath)
+	if err != nil {
+		t.Fatalf("LoadMemoryIndex failed: %v", err)
+	}
+	multi := NewMultiIndex(Library{Name: SourcePubChem, Index: memory})
+
+	for name, idx := range map[string]CompoundIndex{"sqlite": sqlite, "memory": memory, "multi": multi} {
+		for _, tc := range []struct {
+			opts QueryOptions
+			want []string
+		}{
+			{QueryOptions{}, []string{"5", "12", "STD-7"}},
+			{QueryOptions{Offset: 2, MaxHits: 1}, []string{"STD-7"}},
+		} {
+			compounds, _, err := idx.QueryByFormula(context.Background(), "CH4", tc.opts)
+			if err != nil {
+				t.Fatalf("%s: QueryByFormula failed: %v", name, err)
+			}
+			var got []string
+			for _, c := range compounds {
+				got = append(got, c.Identifier)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s %+v: order mismatch (-want +got):\n%s", name, tc.opts, diff)
+			}
+		}
+	}
+}
+
+// TestProperties verifies that both indexes load the property columns of a CSV, leaving
+// the empty ones unknown.
+func TestProperties(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "properties.csv")
+	content := `Identifier,Literature_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,ExactMass,CompoundName,Charge,MolecularWeight,XLogP,TPSA,HBondDonorCount,HBondAcceptorCount,HeavyAtomCount
+2519,100,50,C8H10N4O2,CN1C=NC2=C1C(=O)N(C(=O)N2C)C,InChI=1S/C8H10N4O2/c1-10-4-9-6-5(10)7(13)12(3)8(14)11(6)2/h4H;1-3H3,RYYVLZVUVIJVGH-UHFFFAOYSA-N,194.08,Caffeine,0,194.19,-0.1,58.4,0,3,14
+5234,10,5,ClNa,[Na+].[Cl-],InChI=1S/ClH.Na/h1H;/q;+1/p-1,FAPWRFPIFSIZLT-UHFFFAOYSA-M,57.96,Sodium chloride,0,58.44,,0,0,1,2
+`
+	if err := os.WriteFile(csvPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+
+	sqlite, err := LoadCSVToPrivateMemory(csvPath)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	defer sqlite.Close()
+	memory, err := LoadMemoryIndex(csvPath)
+	if err != nil {
+		t.Fatalf("LoadMemoryIndex failed: %v", err)
+	}
+
+	intp := func(v int) *int { return &v }
+	floatp := func(v float64) *float64 { return &v }
+	want := map[string]Properties{
+		"2519": {Charge: intp(0), MolecularWeight: floatp(194.19), XLogP: floatp(-0.1), TPSA: floatp(58.4),
+			HBondDonorCount: intp(0), HBondAcceptorCount: intp(3), HeavyAtomCount: intp(14)},
+		"5234": {Charge: intp(0), MolecularWeight: floatp(58.44), TPSA: floatp(0),
+			HBondDonorCount: intp(0), HBondAcceptorCount: intp(1), HeavyAtomCount: intp(2)},
+	}
+	for name, idx := range map[string]CompoundIndex{"sqlite": sqlite, "memory": memory} {
+		for id, props := range want {
+			compounds, _, err := idx.QueryByPubChemID(context.Background(), id, QueryOptions{})
+			if err != nil || len(compounds) != 1 {
+				t.Fatalf("%s: QueryByPubChemID(%s) = %d compounds, %v", name, id, len(compounds), err)
+			}
+			if diff := cmp.Diff(props, compounds[0].Properties); diff != "" {
+				t.Errorf("%s: properties of %s mismatch (-want +got):\n%s", name, id, diff)
+			}
+		}
+	}
+
+	// Legacy rows have no property columns, malformed ones are rejected
+	if props, err := ParseProperties(nil); err != nil || props != (Properties{}) {
+		t.Errorf("ParseProperties(nil) = %+v, %v, want unknown properties", props, err)
+	}
+	for _, fields := range [][]string{
+		{"0", "18.02", "-0.5"},
+		{"0", "18.02", "-0.5", "1", "one", "1", "1"},
+		{"0.5", "18.02", "-0.5", "1", "1", "1", "1"},
+	} {
+		if _, err := ParseProperties(fields); err == nil {
+			t.Errorf("ParseProperties(%q): expected an error", fields)
+		}
+	}
+}
+
+func TestQueryFields(t *testing.T) {
+	idx := loadTestIndex(t)
+	defer idx.Close()
+
+	// Lookups leave the fields out of the SQL, except those hits are ranked by
+	opts := QueryOptions{Fields: FieldIdentifier | FieldInChIKey, TopHitOnly: true}
+	want := &Compound{Identifier: "1", InChIKey: "MYFAKEINCHIKEY-ISRIGHTHER-E", ExactMass: 100, LiteratureCount: 10, PatentCount: 2}
+	single, _, err := idx.QueryBySmiles(context.Background(), "O", opts)
+	if err != nil || len(single) != 1 {
+		t.Fatalf("QueryBySmiles = %d compounds, %v", len(single), err)
+	}
+	if diff := cmp.Diff(want, single[0]); diff != "" {
+		t.Errorf("single lookup mismatch (-want +got):\n%s", diff)
+	}
+	batch, _, err := idx.QueryByPubChemIDs(context.Background(), []string{"1"}, opts)
+	if err != nil || len(batch["1"]) != 1 {
+		t.Fatalf("QueryByPubChemIDs = %v, %v", batch, err)
+	}
+	if diff := cmp.Diff(want, batch["1"][0]); diff != "" {
+		t.Errorf("batch lookup mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		names   string
+		want    Fields
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"identifier, inchikey", FieldIdentifier | FieldInChIKey, false},
+		{"xlogp,tpsa,", FieldXLogP | FieldTPSA, false},
+		{"identifier,inchi_key", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFields(tt.names)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFields(%q) = %v, %v, want %v (error: %v)", tt.names, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if diff := cmp.Diff([]string{"identifier", "tpsa"}, (FieldTPSA | FieldIdentifier).Names()); diff != "" {
+		t.Errorf("Names mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMultiIndex(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "lab.csv")
+	content := `Identifier,PubMed_Count,Patent_Count,MolecularFormula,SMILES,InChI,InChIKey,MonoisotopicMass,CompoundName
+LAB-1,15,0,H2O,O,InChI=1S/H2O/h1H2,MYFAKEINCHIKEY-LABSTANDAR-N,99.5,Water standard
+`
+	if err := os.WriteFile(csvPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	pubchem, err := LoadCSVToPrivateMemory(testCSV)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	lab, err := LoadCSVToPrivateMemory(csvPath)
+	if err != nil {
+		t.Fatalf("LoadCSVToPrivateMemory failed: %v", err)
+	}
+	idx := NewMultiIndex(Library{Name: SourcePubChem, Index: pubchem}, Library{Name: "lab", Index: lab})
+	defer idx.Close()
+	ctx := context.Background()
+
+	type hit struct{ Identifier, Source string }
+	hits := func(compounds []*Compound) []hit {
+		var h []hit
+		for _, c := range compounds {
+			h = append(h, hit{c.Identifier, c.Source})
+		}
+		return h
+	}
+
+	// Hits of both libraries are ranked together by score: 14.7, 10.5 then 7.6
+	all := []hit{{"2", SourcePubChem}, {"LAB-1", "lab"}, {"1", SourcePubChem}}
+	tests := []struct {
+		name  string
+		query func() ([]*Compound, int, error)
+		want  []hit
+		total int
+	}{
+		{"first block", func() ([]*Compound, int, error) {
+			return idx.QueryByFirstBlock(ctx, "MYFAKEINCHIKEY", QueryOptions{})
+		}, all, 3},
+		{"mass", func() ([]*Compound, int, error) {
+			return idx.QueryByMass(ctx, 99.5, 0.6, QueryOptions{})
+		}, all, 3},
+		{"paged", func() ([]*Compound, int, error) {
+			return idx.QueryByFirstBlock(ctx, "MYFAKEINCHIKEY", QueryOptions{Offset: 1, MaxHits: 1})
+		}, all[1:2], 3},
+		{"top hit", func() ([]*Compound, int, error) {
+			return idx.QueryByFirstBlock(ctx, "MYFAKEINCHIKEY", QueryOptions{TopHitOnly: true, Rank: RankByPatent})
+		}, all[:1], 3},
+		{"one library", func() ([]*Compound, int, error) {
+			return idx.QueryByName(ctx, "Water standard", QueryOptions{})
+		}, []hit{{"LAB-1", "lab"}}, 1},
+		{"past the last page", func() ([]*Compound, int, error) {
+			return idx.QueryByFirstBlock(ctx, "MYFAKEINCHIKEY", QueryOptions{Offset: 3})
+		}, nil, 0},
+	}
+	for _, tt := range tests {
+		compounds, total, err := tt.query()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(tt.want, hits(compounds)); diff != "" || total != tt.total {
+			t.Errorf("%s: total %d, want %d, hits mismatch (-want +got):\n%s", tt.name, total, tt.total, diff)
+		}
+	}
+
+	// Batch lookups merge the hits of each value
+	pages, totals, err := idx.QueryByFirstBlocks(ctx, []string{"MYFAKEINCHIKEY", "FAKEFORMALDEHY", "NOTAFIRSTBLOC"}, QueryOptions{TopHitOnly: true})
+	if err != nil {
+		t.Fatalf("QueryByFirstBlocks failed: %v", err)
+	}
+	got := map[string][]hit{}
+	for key, compounds := range pages {
+		got[key] = hits(compounds)
+	}
+	wantPages := map[string][]hit{"MYFAKEINCHIKEY": all[:1], "FAKEFORMALDEHY": {{"3", SourcePubChem}}}
+	if diff := cmp.Diff(wantPages, got); diff != "" {
+		t.Errorf("QueryByFirstBlocks mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]int{"MYFAKEINCHIKEY": 3, "FAKEFORMALDEHY": 1}, totals); diff != "" {
+		t.Errorf("QueryByFirstBlocks totals mismatch (-want +got):\n%s", diff)
+	}
+
+	// Suggestions of both libraries are merged, the metadata is that of the first one
+	suggestions, err := idx.QuerySuggestions(ctx, "wat", 10)
+	if err != nil {
+		t.Fatalf("QuerySuggestions failed: %v", err)
+	}
+	var values []string
+	for _, s := range suggestions {
+		values = append(values, s.Value)
+	}
+	if diff := cmp.Diff([]string{"Water standard", "Water"}, values); diff != "" {
+		t.Errorf("QuerySuggestions mismatch (-want +got):\n%s", diff)
+	}
+	if idx.Metadata() != pubchem.Metadata() {
+		t.Error("expected the metadata of the PubChem index")
+	}
+}
+
 // TestQuery_ClosedDB verifies that all QueryBy* methods surface an error
 // (rather than panic) when the underlying database has been closed.
 func TestQuery_ClosedDB(t *testing.T) {
@@ -296,18 +1521,26 @@ func TestQuery_ClosedDB(t *testing.T) {
 
 	queries := []struct {
 		name string
-		fn   func() ([]*Compound, error)
+		fn   func() ([]*Compound, int, error)
 	}{
-		{"QueryByInChIKey", func() ([]*Compound, error) { return idx.QueryByInChIKey("MYFAKEINCHIKEY-ISRIGHTHER-E", false) }},
-		{"QueryByFirstBlock", func() ([]*Compound, error) { return idx.QueryByFirstBlock("MYFAKEINCHIKEY", false) }},
-		{"QueryByInChI", func() ([]*Compound, error) { return idx.QueryByInChI("InChI=1S/H2O/h1H2", false) }},
-		{"QueryBySmiles", func() ([]*Compound, error) { return idx.QueryBySmiles("O", false) }},
-		{"QueryByFormula", func() ([]*Compound, error) { return idx.QueryByFormula("H2O", false) }},
+		{"QueryByInChIKey", func() ([]*Compound, int, error) { return idx.QueryByInChIKey(context.Background(), "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{}) }},
+		{"QueryByFirstBlock", func() ([]*Compound, int, error) { return idx.QueryByFirstBlock(context.Background(), "MYFAKEINCHIKEY", QueryOptions{}) }},
+		{"QueryByInChI", func() ([]*Compound, int, error) { return idx.QueryByInChI(context.Background(), "InChI=1S/H2O/h1H2", QueryOptions{}) }},
+		{"QueryBySmiles", func() ([]*Compound, int, error) { return idx.QueryBySmiles(context.Background(), "O", QueryOptions{}) }},
+		{"QueryByFormula", func() ([]*Compound, int, error) { return idx.QueryByFormula(context.Background(), "H2O", QueryOptions{}) }},
+		{"QueryByMass", func() ([]*Compound, int, error) { return idx.QueryByMass(context.Background(), 100, 0.01, QueryOptions{}) }},
+		{"QueryByName", func() ([]*Compound, int, error) { return idx.QueryByName(context.Background(), "Water", QueryOptions{}) }},
+		{"QueryByNameCaseInsensitive", func() ([]*Compound, int, error) { return idx.QueryByNameCaseInsensitive(context.Background(), "water", QueryOptions{}) }},
+		{"QueryBySynonym", func() ([]*Compound, int, error) { return idx.QueryBySynonym(context.Background(), "Oxidane", QueryOptions{}) }},
+		{"QueryByInChIKeys", func() ([]*Compound, int, error) {
+			_, _, err := idx.QueryByInChIKeys(context.Background(), []string{"MYFAKEINCHIKEY-ISRIGHTHER-E"}, QueryOptions{})
+			return nil, 0, err
+		}},
 	}
 
 	for _, q := range queries {
 		t.Run(q.name, func(t *testing.T) {
-			_, err := q.fn()
+			_, _, err := q.fn()
 			if err == nil {
 				t.Errorf("%s: expected error on closed DB, got nil", q.name)
 			}
@@ -321,7 +1554,7 @@ func TestCompoundFields(t *testing.T) {
 	idx := loadTestIndex(t)
 	defer idx.Close()
 
-	compounds, err := idx.QueryByInChIKey("MYFAKEINCHIKEY-ISRIGHTHER-E", false)
+	compounds, _, err := idx.QueryByInChIKey(context.Background(), "MYFAKEINCHIKEY-ISRIGHTHER-E", QueryOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
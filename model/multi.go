@@ -0,0 +1,310 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// SourcePubChem is the source of the hits of the main database, built from PubChem
+const SourcePubChem = "pubchem"
+
+// Library is an index searched by a MultiIndex, its hits are tagged with its Name
+type Library struct {
+	Name  string
+	Index CompoundIndex
+}
+
+// MultiIndex searches several indexes as one, e.g. PubChem alongside an in-house library
+// of standards built with the same schema. Every hit is tagged with the Name of the
+// library it came from in Compound.Source. Hits of all libraries are ranked together like
+// the hits of a single index, ties go to the lowest CID and then to the library listed first
+type MultiIndex struct {
+	libraries []Library
+}
+
+// NewMultiIndex searches the libraries, the metadata is that of the first one
+func NewMultiIndex(libraries ...Library) *MultiIndex {
+	return &MultiIndex{libraries: libraries}
+}
+
+// unpaged returns the options of the lookup of each library: enough hits of each to fill
+// the page of opts once merged
+func unpaged(opts QueryOptions) QueryOptions {
+	limit, offset := opts.page()
+	opts.TopHitOnly = false
+	opts.Offset = 0
+	opts.MaxHits = 0
+	if limit >= 0 {
+		opts.MaxHits = offset + limit
+	}
+	return opts
+}
+
+// cid orders identifiers numerically like the CIDs of the SQL ranking, identifiers that
+// aren't CIDs come last
+func cid(c *Compound) int {
+	id, err := strconv.Atoi(c.Identifier)
+	if err != nil {
+		return math.MaxInt
+	}
+	return id
+}
+
+// byRank orders hits like orderByRank, breaking ties on rank with tiebreak then the lowest CID
+func byRank(r Ranking, tiebreak func(a, b *Compound) int) func(a, b *Compound) int {
+	r = r.orDefault()
+	return func(a, b *Compound) int {
+		return cmp.Or(cmp.Compare(r.Score(b), r.Score(a)), tiebreak(a, b), cmp.Compare(cid(a), cid(b)))
+	}
+}
+
+func noTiebreak(a, b *Compound) int { return 0 }
+
+// tag sets the source of the hits of library
+func (l Library) tag(compounds []*Compound) []*Compound {
+	for _, c := range compounds {
+		c.Source = l.Name
+	}
+	return compounds
+}
+
+// lookup runs a single lookup on every library and merges their hits into the page of opts
+func (idx *MultiIndex) lookup(opts QueryOptions, tiebreak func(a, b *Compound) int, query func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error)) ([]*Compound, int, error) {
+	var merged []*Compound
+	var total int
+	for _, l := range idx.libraries {
+		compounds, n, err := query(l.Index, unpaged(opts))
+		if err != nil {
+			return nil, 0, err
+		}
+		merged = append(merged, l.tag(compounds)...)
+		total += n
+	}
+	slices.SortStableFunc(merged, byRank(opts.Rank, tiebreak))
+	merged = opts.Paginate(merged)
+	if len(merged) == 0 {
+		return nil, 0, nil
+	}
+	return merged, total, nil
+}
+
+// batch runs a batch lookup on every library and merges their hits per key into the page
+// of opts, leaving out keys without hits in the page
+func (idx *MultiIndex) batch(opts QueryOptions, query func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error)) (map[string][]*Compound, map[string]int, error) {
+	hits := make(map[string][]*Compound)
+	totals := make(map[string]int)
+	for _, l := range idx.libraries {
+		libraryHits, libraryTotals, err := query(l.Index, unpaged(opts))
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, compounds := range libraryHits {
+			hits[key] = append(hits[key], l.tag(compounds)...)
+			totals[key] += libraryTotals[key]
+		}
+	}
+	for key, compounds := range hits {
+		slices.SortStableFunc(compounds, byRank(opts.Rank, noTiebreak))
+		if page := opts.Paginate(compounds); len(page) > 0 {
+			hits[key] = page
+		} else {
+			delete(hits, key)
+			delete(totals, key)
+		}
+	}
+	return hits, totals, nil
+}
+
+func (idx *MultiIndex) QueryByPubChemID(ctx context.Context, id string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByPubChemID(ctx, id, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChIKey(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByInChIKey(ctx, key, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChIKeyIgnoringProtonation(ctx context.Context, key string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByInChIKeyIgnoringProtonation(ctx, key, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByFirstBlock(ctx context.Context, block string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByFirstBlock(ctx, block, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByInChI(ctx, inchi, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryBySkeletonInChI(ctx context.Context, inchi string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryBySkeletonInChI(ctx, inchi, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryBySmiles(ctx context.Context, smiles string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryBySmiles(ctx, smiles, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByFormula(ctx context.Context, formula string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByFormula(ctx, formula, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByFormulaRange(ctx context.Context, ranges []ElementRange, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByFormulaRange(ctx, ranges, opts)
+	})
+}
+
+// QueryByMass breaks ties on rank by the smallest mass error, like a single index
+func (idx *MultiIndex) QueryByMass(ctx context.Context, mass, tolerance float64, opts QueryOptions) ([]*Compound, int, error) {
+	byMassError := func(a, b *Compound) int {
+		return cmp.Compare(math.Abs(a.ExactMass-mass), math.Abs(b.ExactMass-mass))
+	}
+	return idx.lookup(opts, byMassError, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByMass(ctx, mass, tolerance, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByName(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByName(ctx, name, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByNameCaseInsensitive(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByNameCaseInsensitive(ctx, name, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryBySynonym(ctx context.Context, name string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryBySynonym(ctx, name, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByXref(ctx context.Context, source, xref string, opts QueryOptions) ([]*Compound, int, error) {
+	return idx.lookup(opts, noTiebreak, func(index CompoundIndex, opts QueryOptions) ([]*Compound, int, error) {
+		return index.QueryByXref(ctx, source, xref, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByPubChemIDs(ctx context.Context, ids []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryByPubChemIDs(ctx, ids, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChIKeys(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryByInChIKeys(ctx, keys, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChIKeysIgnoringProtonation(ctx context.Context, keys []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryByInChIKeysIgnoringProtonation(ctx, keys, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByFirstBlocks(ctx context.Context, blocks []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryByFirstBlocks(ctx, blocks, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryByInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryByInChIs(ctx, inchis, opts)
+	})
+}
+
+func (idx *MultiIndex) QueryBySkeletonInChIs(ctx context.Context, inchis []string, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+	return idx.batch(opts, func(index CompoundIndex, opts QueryOptions) (map[string][]*Compound, map[string]int, error) {
+		return index.QueryBySkeletonInChIs(ctx, inchis, opts)
+	})
+}
+
+// AttachXrefs attaches the cross-references of each compound from the library it came from
+func (idx *MultiIndex) AttachXrefs(ctx context.Context, compounds []*Compound) error {
+	for _, l := range idx.libraries {
+		var fromLibrary []*Compound
+		for _, c := range compounds {
+			if c.Source == l.Name {
+				fromLibrary = append(fromLibrary, c)
+			}
+		}
+		if len(fromLibrary) == 0 {
+			continue
+		}
+		if err := l.Index.AttachXrefs(ctx, fromLibrary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuerySuggestions merges the suggestions of the libraries by score, keeping the best
+// scored suggestion of values suggested by several libraries
+func (idx *MultiIndex) QuerySuggestions(ctx context.Context, prefix string, limit int) ([]*Suggestion, error) {
+	var suggestions []*Suggestion
+	for _, l := range idx.libraries {
+		s, err := l.Index.QuerySuggestions(ctx, prefix, limit)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s...)
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b *Suggestion) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	seen := make(map[[2]string]bool)
+	suggestions = slices.DeleteFunc(suggestions, func(s *Suggestion) bool {
+		key := [2]string{s.Type, s.Value}
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		return false
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// Metadata describes the first library, usually the PubChem dataset
+func (idx *MultiIndex) Metadata() *Metadata {
+	if len(idx.libraries) == 0 {
+		return nil
+	}
+	return idx.libraries[0].Index.Metadata()
+}
+
+// Close closes every library
+func (idx *MultiIndex) Close() error {
+	var errs []error
+	for _, l := range idx.libraries {
+		errs = append(errs, l.Index.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"strconv"
+)
+
+// Ranking orders the hits of a lookup by a weighted sum of literature and patent counts,
+// highest first. Ties, and every hit of the CID ranking (both weights 0), go to the lowest CID
+type Ranking struct {
+	Name             string
+	LiteratureWeight float64
+	PatentWeight     float64
+}
+
+var (
+	RankByScore      = Ranking{Name: "score", LiteratureWeight: 0.7, PatentWeight: 0.3}
+	RankByLiterature = Ranking{Name: "literature", LiteratureWeight: 1}
+	RankByPatent     = Ranking{Name: "patent", PatentWeight: 1}
+	RankByCID        = Ranking{Name: "cid"}
+)
+
+// CustomRanking weighs literature and patent counts with the given weights
+func CustomRanking(literatureWeight, patentWeight float64) Ranking {
+	return Ranking{Name: "custom", LiteratureWeight: literatureWeight, PatentWeight: patentWeight}
+}
+
+// orDefault resolves the zero Ranking to RankByScore
+func (r Ranking) orDefault() Ranking {
+	if r == (Ranking{}) {
+		return RankByScore
+	}
+	return r
+}
+
+// Score is the value hits are ranked by
+func (r Ranking) Score(c *Compound) float64 {
+	r = r.orDefault()
+	return r.LiteratureWeight*float64(c.LiteratureCount) + r.PatentWeight*float64(c.PatentCount)
+}
+
+// String names the ranking as accepted by the rank request option, e.g. "patent" or "custom:0.2,0.8"
+func (r Ranking) String() string {
+	r = r.orDefault()
+	if r.Name != "custom" {
+		return r.Name
+	}
+	return "custom:" + strconv.FormatFloat(r.LiteratureWeight, 'g', -1, 64) + "," +
+		strconv.FormatFloat(r.PatentWeight, 'g', -1, 64)
+}
+
+// QueryOptions are the options shared by the lookups. The zero value returns every hit
+// ranked by score
+type QueryOptions struct {
+	TopHitOnly bool
+	Rank       Ranking
+	// MaxHits limits the hits returned after skipping the first Offset ones, 0 means no
+	// limit. TopHitOnly is a MaxHits of 1
+	MaxHits int
+	Offset  int
+	// Fields restricts the fields selected for each hit, the others are left empty. The
+	//   identifier, exact mass and counts are always selected since hits are ranked by them
+	Fields Fields
+}
+
+// page returns the LIMIT and OFFSET of a lookup, a negative limit means no limit
+func (o QueryOptions) page() (limit, offset int) {
+	switch {
+	case o.TopHitOnly:
+		return 1, o.Offset
+	case o.MaxHits > 0:
+		return o.MaxHits, o.Offset
+	}
+	return -1, o.Offset
+}
+
+// Paginate applies the page of o to hits ranked in Go, e.g. merged from several lookups
+func (o QueryOptions) Paginate(compounds []*Compound) []*Compound {
+	limit, offset := o.page()
+	if offset >= len(compounds) {
+		return nil
+	}
+	compounds = compounds[offset:]
+	if limit >= 0 && limit < len(compounds) {
+		compounds = compounds[:limit]
+	}
+	return compounds
+}
+
+// rankArgs appends the ranking weights bound by orderByRank to the lookup args
+func (o QueryOptions) rankArgs(args ...any) []any {
+	r := o.Rank.orDefault()
+	return append(args, r.LiteratureWeight, r.PatentWeight)
+}
+
+// pageArgs appends the LIMIT and OFFSET bound by limitPage
+func (o QueryOptions) pageArgs(args ...any) []any {
+	limit, offset := o.page()
+	return append(args, limit, offset)
+}
+
+// lookupArgs binds the selected fields, the args of a lookup, then its ranking weights
+// and page
+func (o QueryOptions) lookupArgs(args ...any) []any {
+	return o.pageArgs(o.rankArgs(o.fieldArgs(args...)...)...)
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Properties are the physicochemical properties PubChem computes for a compound. They are
+// nil when unknown: datasets built before they were fetched don't have them, and PubChem
+// leaves some out, e.g. XLogP for salts and metal complexes
+type Properties struct {
+	Charge             *int     `json:"charge,omitempty"`
+	MolecularWeight    *float64 `json:"molecular_weight,omitempty"`
+	XLogP              *float64 `json:"xlogp,omitempty"`
+	TPSA               *float64 `json:"tpsa,omitempty"` // topological polar surface area, in Å²
+	HBondDonorCount    *int     `json:"hbond_donor_count,omitempty"`
+	HBondAcceptorCount *int     `json:"hbond_acceptor_count,omitempty"`
+	HeavyAtomCount     *int     `json:"heavy_atom_count,omitempty"`
+}
+
+// propertyColumns are the columns of the properties, in the order of the CSV and of
+// InsertSQL
+var propertyColumns = []string{
+	"charge", "molecular_weight", "xlogp", "tpsa",
+	"hbond_donor_count", "hbond_acceptor_count", "heavy_atom_count",
+}
+
+// CSVFields are the number of fields in a row of a CTS-Lite CSV: the compound columns, then
+// the property columns. Rows of datasets built before the properties were fetched end after
+// the compound columns, LegacyCSVFields
+const (
+	LegacyCSVFields = 9
+	CSVFields       = LegacyCSVFields + 7
+)
+
+// ParseProperties parses the property fields of a CTS-Lite CSV row, the fields after the
+// compound columns. Empty fields, or no fields for legacy rows, are unknown properties
+func ParseProperties(fields []string) (Properties, error) {
+	var p Properties
+	if len(fields) == 0 {
+		return p, nil
+	}
+	if len(fields) != len(propertyColumns) {
+		return p, fmt.Errorf("got %d property fields, expected %d", len(fields), len(propertyColumns))
+	}
+
+	var err error
+	parseInt := func(i int, dest **int) {
+		if err != nil || fields[i] == "" {
+			return
+		}
+		v, perr := strconv.Atoi(fields[i])
+		if perr != nil {
+			err = fmt.Errorf("invalid %s %q", propertyColumns[i], fields[i])
+			return
+		}
+		*dest = &v
+	}
+	parseFloat := func(i int, dest **float64) {
+		if err != nil || fields[i] == "" {
+			return
+		}
+		v, perr := strconv.ParseFloat(fields[i], 64)
+		if perr != nil {
+			err = fmt.Errorf("invalid %s %q", propertyColumns[i], fields[i])
+			return
+		}
+		*dest = &v
+	}
+	parseInt(0, &p.Charge)
+	parseFloat(1, &p.MolecularWeight)
+	parseFloat(2, &p.XLogP)
+	parseFloat(3, &p.TPSA)
+	parseInt(4, &p.HBondDonorCount)
+	parseInt(5, &p.HBondAcceptorCount)
+	parseInt(6, &p.HeavyAtomCount)
+	if err != nil {
+		return Properties{}, err
+	}
+	return p, nil
+}
+
+// Args returns the properties as args of InsertSQL, unknown properties are NULL
+func (p Properties) Args() []any {
+	return []any{
+		nullable(p.Charge), nullable(p.MolecularWeight), nullable(p.XLogP), nullable(p.TPSA),
+		nullable(p.HBondDonorCount), nullable(p.HBondAcceptorCount), nullable(p.HeavyAtomCount),
+	}
+}
+
+// scanDest returns the scan destinations of the property columns
+func (p *Properties) scanDest() []any {
+	return []any{
+		&p.Charge, &p.MolecularWeight, &p.XLogP, &p.TPSA,
+		&p.HBondDonorCount, &p.HBondAcceptorCount, &p.HeavyAtomCount,
+	}
+}
+
+// CSVValues formats the properties as CSV fields, unknown properties are empty
+func (p Properties) CSVValues() []string {
+	return []string{
+		formatInt(p.Charge), formatFloat(p.MolecularWeight), formatFloat(p.XLogP), formatFloat(p.TPSA),
+		formatInt(p.HBondDonorCount), formatInt(p.HBondAcceptorCount), formatInt(p.HeavyAtomCount),
+	}
+}
+
+func nullable[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func formatInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"sync"
+)
+
+// IndexHolder serves the current CompoundIndex and replaces it without downtime: requests
+// acquire the index they run on, and a replaced index is only closed once the requests
+// still running on it are done
+type IndexHolder struct {
+	mu      sync.Mutex
+	swapMu  sync.Mutex
+	current *heldIndex
+}
+
+// heldIndex counts the requests running on an index
+type heldIndex struct {
+	index    CompoundIndex
+	inFlight sync.WaitGroup
+}
+
+// NewIndexHolder serves index until it is swapped
+func NewIndexHolder(index CompoundIndex) *IndexHolder {
+	return &IndexHolder{current: &heldIndex{index: index}}
+}
+
+// Acquire returns the current index and the func to call once the request is done with it
+func (h *IndexHolder) Acquire() (CompoundIndex, func()) {
+	h.mu.Lock()
+	held := h.current
+	held.inFlight.Add(1)
+	h.mu.Unlock()
+	return held.index, sync.OnceFunc(held.inFlight.Done)
+}
+
+// Swap makes index the current one, then waits for the requests running on the previous
+// index before closing it. Concurrent swaps are applied one after the other
+func (h *IndexHolder) Swap(index CompoundIndex) error {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	h.mu.Lock()
+	previous := h.current
+	h.current = &heldIndex{index: index}
+	h.mu.Unlock()
+
+	previous.inFlight.Wait()
+	return previous.index.Close()
+}
+
+// Close closes the current index, once its requests are done
+func (h *IndexHolder) Close() error {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+
+	h.mu.Lock()
+	held := h.current
+	h.mu.Unlock()
+
+	held.inFlight.Wait()
+	return held.index.Close()
+}
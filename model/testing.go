@@ -3,6 +3,7 @@ package model
 // These methods are only used by the tests, to load a 'mock' database
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
 	"fmt"
@@ -53,10 +54,16 @@ func loadCSVToSQLite(csvPath, dsn string) (*PubChemIndex, error) {
 	// Single connection ensures all operations share the same in-memory DB
 	db.SetMaxOpenConns(1)
 
-	reader := csv.NewReader(f)
+	sum := sha256.New()
+	reader := csv.NewReader(io.TeeReader(f, sum))
 	_, _ = reader.Read() // skip header
 
-	if err := populateDB(db, reader); err != nil {
+	count, err := populateDB(db, reader)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := WriteMetadata(db, NewMetadata(DatasetName(csvPath), sum.Sum(nil), count)); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -65,26 +72,29 @@ func loadCSVToSQLite(csvPath, dsn string) (*PubChemIndex, error) {
 	return newIndex(db)
 }
 
-// populateDB creates the schema and bulk-inserts rows from a CSV reader (for tests)
+// populateDB creates the schema and bulk-inserts rows from a CSV reader (for tests),
+// returning the number of compounds inserted
 // CSV column order: identifier, literature_count, patent_count,
-//	molecular_formula, smiles, inchi, inchikey, exact_mass, compound_name
-func populateDB(db *sql.DB, reader *csv.Reader) error {
+//	molecular_formula, smiles, inchi, inchikey, exact_mass, compound_name, then the
+//	optional property columns, see ParseProperties
+func populateDB(db *sql.DB, reader *csv.Reader) (int, error) {
 	if _, err := db.Exec(CreateTableSQL); err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+		return 0, fmt.Errorf("failed to create table: %w", err)
 	}
 
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
 	stmt, err := tx.Prepare(InsertSQL)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to prepare insert: %w", err)
+		return 0, fmt.Errorf("failed to prepare insert: %w", err)
 	}
 	defer stmt.Close()
 
+	count := 0
 	for {
 		line, err := reader.Read()
 		if err == io.EOF {
@@ -92,7 +102,7 @@ func populateDB(db *sql.DB, reader *csv.Reader) error {
 		}
 		if err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to read CSV row: %w", err)
+			return 0, fmt.Errorf("failed to read CSV row: %w", err)
 		}
 
 		// Skip lines without inchikeys
@@ -100,30 +110,43 @@ func populateDB(db *sql.DB, reader *csv.Reader) error {
 			continue
 		}
 
-		if _, err := stmt.Exec(
+		props, err := ParseProperties(line[LegacyCSVFields:])
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("row %d: %w", count+1, err)
+		}
+
+		args := append([]any{
 			line[0], // identifier
 			line[6], // inchikey
 			line[6][:14], // first_block
 			line[5], // inchi
+			SkeletonInChI(line[5]), // skeleton_inchi
 			line[4], // smiles
 			line[8], // compound_name
 			line[3], // molecular_formula
 			line[7], // exact_mass
 			line[1], // literature_count
 			line[2], // patent_count
-		); err != nil {
+		}, props.Args()...)
+		if _, err := stmt.Exec(append(args, ElementCounts(line[3])...)...); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to insert row: %w", err)
+			return 0, fmt.Errorf("failed to insert row: %w", err)
 		}
+		count++
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	if _, err := db.Exec(CreateIndexSQL); err != nil {
-		return fmt.Errorf("failed to create indices: %w", err)
+		return 0, fmt.Errorf("failed to create indices: %w", err)
+	}
+
+	if _, err := db.Exec(CreateNameIndexSQL); err != nil {
+		return 0, fmt.Errorf("failed to create name index: %w", err)
 	}
 
-	return nil
+	return count, nil
 }
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Sources of cross-reference identifiers, as stored in xrefs.source
+const (
+	XrefCAS   = "cas"
+	XrefHMDB  = "hmdb"
+	XrefKEGG  = "kegg"
+	XrefChEBI = "chebi"
+)
+
+var (
+	casPattern   = regexp.MustCompile(`^\d{2,7}-\d{2}-\d$`)
+	hmdbPattern  = regexp.MustCompile(`(?i)^HMDB(\d{5}|\d{7})$`)
+	keggPattern  = regexp.MustCompile(`^C\d{5}$`)
+	chebiPattern = regexp.MustCompile(`(?i)^CHEBI:(\d+)$`)
+)
+
+// ErrCASChecksum is returned by ParseXref for CAS numbers whose check digit is wrong
+var ErrCASChecksum = errors.New("invalid CAS number check digit")
+
+// ParseXref recognizes a CAS number (50-00-0), HMDB ID (HMDB0000001), KEGG compound
+// (C00001) or ChEBI ID (CHEBI:15377), returning its source and canonical form: HMDB IDs
+// padded to 7 digits and ChEBI IDs uppercased. source is empty if id is none of these
+func ParseXref(id string) (source, xref string, err error) {
+	switch {
+	case casPattern.MatchString(id):
+		if !validCASChecksum(id) {
+			return XrefCAS, "", ErrCASChecksum
+		}
+		return XrefCAS, id, nil
+
+	case hmdbPattern.MatchString(id):
+		digits := hmdbPattern.FindStringSubmatch(id)[1]
+		if len(digits) == 5 {
+			digits = "00" + digits
+		}
+		return XrefHMDB, "HMDB" + digits, nil
+
+	case keggPattern.MatchString(id):
+		return XrefKEGG, id, nil
+
+	case chebiPattern.MatchString(id):
+		return XrefChEBI, "CHEBI:" + chebiPattern.FindStringSubmatch(id)[1], nil
+	}
+	return "", "", nil
+}
+
+// validCASChecksum checks the last digit of a CAS number, the sum of the other digits
+// weighted by their position from the right, modulo 10
+func validCASChecksum(cas string) bool {
+	digits := strings.ReplaceAll(cas[:len(cas)-2], "-", "")
+	sum := 0
+	for i := range len(digits) {
+		sum += int(digits[len(digits)-1-i]-'0') * (i + 1)
+	}
+	return sum%10 == int(cas[len(cas)-1]-'0')
+}
@@ -83,7 +83,7 @@ test('CSV download contains correct headers', async ({ page }) => {
   const header = content.split('\n')[0];
 
   expect(header).toBe(
-    'query,query_type,converted_query,found_match,match_level,error_message,pubchem_cid,inchikey,inchi,smiles,compound_name,molecular_formula,exact_mass,literature_count,patent_count'
+    'query,query_type,converted_query,found_match,match_level,error_message,pubchem_cid,inchikey,inchi,smiles,compound_name,molecular_formula,exact_mass,literature_count,patent_count,charge,molecular_weight,xlogp,tpsa,hbond_donor_count,hbond_acceptor_count,heavy_atom_count,source'
   );
 });
 
@@ -124,7 +124,7 @@ test('CSV download has empty compound fields for no-match', async ({ page }) =>
   expect(dataCols).toBe(headerCols);
   expect(lines[1]).toContain('false');
   // pubchem_cid and compound fields are empty — row ends with many commas
-  expect(lines[1]).toMatch(/false,[^,]*,[^,]*,,,,,,,,,$/);
+  expect(lines[1]).toMatch(/false,[^,]*,[^,]*,,,,,,,,,,,,,,,,,$/);
 });
 
 // Mix of matches and no-matches — exercises the no-match CSV branch in script.js
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"ctslite/api"
 	"ctslite/model"
 	"ctslite/telemetry"
@@ -9,6 +10,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
@@ -36,6 +43,155 @@ func serveDoc(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./web/pages/docs.html")
 }
 
+// defaultCacheSize is the number of lookups cached in front of the SQLite index, see CACHE_SIZE
+const defaultCacheSize = 10_000
+
+// cacheStats counts the hits and misses of the lookup caches across index reloads
+var cacheStats model.CacheStats
+
+// openIndex opens the SQLite database at DB_PATH behind a lookup cache of CACHE_SIZE
+// entries (0 disables it), or loads the CSV at CSV_PATH into memory for small
+// deployments without a database. The libraries of LIBRARY_DBS are searched alongside it
+func openIndex() (model.CompoundIndex, error) {
+	if csvPath := os.Getenv("CSV_PATH"); csvPath != "" {
+		index, err := model.LoadMemoryIndex(csvPath)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading in-memory index: %w", err)
+		}
+		return withLibraries(index)
+	}
+
+	dbPath := "dataset/compounds.db"
+	if envPath := os.Getenv("DB_PATH"); envPath != "" {
+		dbPath = envPath
+	}
+	primary, err := openSQLiteIndex(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	index, err := withLibraries(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSize := defaultCacheSize
+	if size := os.Getenv("CACHE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			index.Close()
+			return nil, fmt.Errorf("Invalid CACHE_SIZE %q", size)
+		}
+		cacheSize = n
+	}
+	if cacheSize == 0 {
+		return index, nil
+	}
+	// Each opened index gets its own cache, so a reload starts from an empty one
+	return model.NewCachedIndex(index, cacheSize, &cacheStats), nil
+}
+
+// openSQLiteIndex opens and verifies an existing SQLite database
+func openSQLiteIndex(dbPath string) (*model.PubChemIndex, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Database file %s does not exist", dbPath)
+	}
+
+	index, err := model.OpenSQLiteIndex(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening SQLite index: %w", err)
+	}
+	if err := index.Verify(); err != nil {
+		index.Close()
+		return nil, fmt.Errorf("Error verifying SQLite index %s: %w", dbPath, err)
+	}
+	return index, nil
+}
+
+// withLibraries searches the SQLite databases of LIBRARY_DBS alongside the main index,
+// e.g. LIBRARY_DBS=standards=/data/standards.db,/data/lab.db. Each entry is name=path or
+// a path named after its file. Hits of the main index have source pubchem, also when
+// LIBRARY_DBS is unset. The main index is closed on error
+func withLibraries(primary model.CompoundIndex) (model.CompoundIndex, error) {
+	libraries := []model.Library{{Name: model.SourcePubChem, Index: primary}}
+	closeAll := func() {
+		for _, l := range libraries {
+			l.Index.Close()
+		}
+	}
+	for _, entry := range strings.Split(os.Getenv("LIBRARY_DBS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, path = model.DatasetName(entry), entry
+		}
+		if slices.ContainsFunc(libraries, func(l model.Library) bool { return l.Name == name }) {
+			closeAll()
+			return nil, fmt.Errorf("Duplicate library name %q in LIBRARY_DBS", name)
+		}
+		index, err := openSQLiteIndex(path)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("Error opening library %s: %w", name, err)
+		}
+		libraries = append(libraries, model.Library{Name: name, Index: index})
+		log.Printf("Searching library %s (%s) alongside the main index", name, path)
+	}
+	return model.NewMultiIndex(libraries...), nil
+}
+
+// reloadIndex opens the index again, e.g. after the database file was replaced, and
+// switches requests to it. On error the current index keeps serving
+func reloadIndex(indexes *model.IndexHolder) error {
+	index, err := openIndex()
+	if err != nil {
+		return err
+	}
+	log.Printf("Switched to the reloaded index, closing the previous one once its requests are done")
+	if err := indexes.Swap(index); err != nil {
+		log.Printf("Error closing the previous index: %v", err)
+	}
+	return nil
+}
+
+// reloadOnSIGHUP reloads the index whenever the process receives SIGHUP
+func reloadOnSIGHUP(indexes *model.IndexHolder) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := reloadIndex(indexes); err != nil {
+				log.Printf("Index reload failed, keeping the current index: %v", err)
+			}
+		}
+	}()
+}
+
+// adminReload serves POST /admin/reload for deployments that can't signal the process,
+// it requires the ADMIN_TOKEN as a bearer token
+func adminReload(indexes *model.IndexHolder, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := reloadIndex(indexes); err != nil {
+			log.Printf("Index reload failed, keeping the current index: %v", err)
+			http.Error(w, "Index reload failed, keeping the current index", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Index reloaded")
+	}
+}
+
 func main() {
 	// Initialize OpenTelemetry (traces, metrics, logs)
 	// Observability must never block startup, so on error we log and continue
@@ -66,17 +222,34 @@ func main() {
 		http.Redirect(w, r, "/docs", http.StatusMovedPermanently)
 	})
 
-	dbPath := "dataset/compounds.db"
-	if envPath := os.Getenv("DB_PATH"); envPath != "" {
-		dbPath = envPath
+	index, err := openIndex()
+	if err != nil {
+		log.Fatal(err)
 	}
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		log.Fatalf("Database file %s does not exist", dbPath)
+	telemetry.RegisterIndexCacheCounters(cacheStats.Hits.Load, cacheStats.Misses.Load)
+
+	// The index can be reloaded without downtime, requests run on the index they started on
+	indexes := model.NewIndexHolder(index)
+	reloadOnSIGHUP(indexes)
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		http.HandleFunc("/admin/reload", adminReload(indexes, token))
 	}
 
-	index, err := model.OpenSQLiteIndex(dbPath)
-	if err != nil {
-		log.Fatalf("Error opening SQLite index: %v", err)
+	// Labs can extend the adduct table used by m/z queries
+	if adductsPath := os.Getenv("ADDUCTS_PATH"); adductsPath != "" {
+		if err := api.LoadAdducts(adductsPath); err != nil {
+			log.Fatalf("Error loading adducts: %v", err)
+		}
+	}
+
+	// Server-side bound on matching a request, e.g. MATCH_TIMEOUT=60s. Matching also stops
+	// when the client disconnects
+	if timeout := os.Getenv("MATCH_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid MATCH_TIMEOUT: %v", err)
+		}
+		api.MatchTimeout = d
 	}
 
 	// Default endpoints for health checks
@@ -87,12 +260,39 @@ func main() {
 	api.StartClassyFireHealthCheck(context.Background())
 	http.HandleFunc("/classyfire/status", corsMiddleware(api.ClassyFireStatus))
 
+	// Adduct table used by m/z queries
+	http.HandleFunc("/adducts", corsMiddleware(api.Adducts))
+
 	// Endpoint for matching against database
 	matchHandler := corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		index, release := indexes.Acquire()
+		defer release()
 		api.Match(index, w, r)
 	})
 	http.Handle("/match", otelhttp.NewHandler(matchHandler, "match"))
 
+	// Endpoint for translating identifiers, e.g. InChIKeys to CAS numbers
+	convertHandler := corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		index, release := indexes.Acquire()
+		defer release()
+		api.Convert(index, w, r)
+	})
+	http.Handle("/convert", otelhttp.NewHandler(convertHandler, "convert"))
+
+	// Dataset the index was built from, for reproducibility
+	http.HandleFunc("/version", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		index, release := indexes.Acquire()
+		defer release()
+		api.Version(index, w, r)
+	}))
+
+	// Type-ahead suggestions for names, InChIKey first blocks and CIDs
+	http.HandleFunc("/suggest", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		index, release := indexes.Acquire()
+		defer release()
+		api.Suggest(index, w, r)
+	}))
+
 	port := ":8080"
 	if p := os.Getenv("PORT"); p != "" {
 		port = ":" + p
@@ -3,6 +3,7 @@ package telemetry
 import (
 	"context"
 	"ctslite/model"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
@@ -22,10 +23,11 @@ const maxLoggedMisses = 5
 
 // MatchOptions carries the per-request /match flags into telemetry.
 type MatchOptions struct {
-	TopHitOnly             bool
-	AllowFirstBlockMatches bool
-	AllowRdkitConversion   bool
-	ClassyFireEnabled      bool
+	TopHitOnly              bool
+	AllowFirstBlockMatches  bool
+	AllowProtonationMatches bool
+	AllowRdkitConversion    bool
+	ClassyFireEnabled       bool
 }
 
 var (
@@ -37,9 +39,12 @@ var (
 	matchHitPercent           metric.Float64Histogram
 	matchDuration             metric.Float64Histogram
 	matchQueriesPerReq        metric.Int64Histogram
+	matchAborted              metric.Int64Counter
+	matchAbortedQueries       metric.Int64Counter
 	classyfireClassifications metric.Int64Counter
 	matchLogger               log.Logger
 	classyfireGaugeOnce       sync.Once
+	indexCacheOnce            sync.Once
 )
 
 // initInstruments lazily creates the metric instruments and logger from the
@@ -66,6 +71,10 @@ func initInstruments() {
 		matchQueriesPerReq, _ = meter.Int64Histogram("match_queries_per_request",
 			metric.WithDescription("Distribution of the number of queries per /match request"),
 			metric.WithExplicitBucketBoundaries(1, 5, 50, 250, 1000, 5000, 25000, 100000))
+		matchAborted, _ = meter.Int64Counter("match_aborted_total",
+			metric.WithDescription("Number of /match and /convert requests abandoned before all queries were matched, split by reason"))
+		matchAbortedQueries, _ = meter.Int64Counter("match_aborted_queries_total",
+			metric.WithDescription("Queries of aborted requests, split by whether they were matched before the abort"))
 		classyfireClassifications, _ = meter.Int64Counter("classyfire_classifications_total",
 			metric.WithDescription("Terminal outcomes of individual ClassyFire classifications, split by status"))
 		matchLogger = logglobal.GetLoggerProvider().Logger(scopeName)
@@ -146,6 +155,7 @@ func RecordMatch(r *http.Request, results []*model.SingleResult, matchCount int,
 		log.String("client_type", clientType),
 		log.Bool("top_hit_only", opts.TopHitOnly),
 		log.Bool("first_block_matches", opts.AllowFirstBlockMatches),
+		log.Bool("protonation_matches", opts.AllowProtonationMatches),
 		log.Bool("rdkit_conversion", opts.AllowRdkitConversion),
 		log.Bool("classyfire_enabled", opts.ClassyFireEnabled),
 		log.Slice("misses", misses...),
@@ -156,6 +166,53 @@ func RecordMatch(r *http.Request, results []*model.SingleResult, matchCount int,
 	matchLogger.Emit(ctx, record)
 }
 
+// abortReason names why matching stopped early: the client went away, or the
+// server-side deadline passed
+func abortReason(cause error) string {
+	switch {
+	case errors.Is(cause, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(cause, context.Canceled):
+		return "client_disconnected"
+	default:
+		return "other"
+	}
+}
+
+// RecordMatchAborted records a request whose matching stopped after completed of its
+// queries, so the work spent on abandoned requests shows up next to RecordMatch
+func RecordMatchAborted(r *http.Request, completed, queryCount int, duration time.Duration, cause error) {
+	initInstruments()
+
+	// The request context is done, the data points must not depend on it
+	ctx := context.WithoutCancel(r.Context())
+
+	clientType := "api"
+	if r.Header.Get("X-CTSL-Client") == "frontend" {
+		clientType = "frontend"
+	}
+	reason := abortReason(cause)
+	reasonAttr := attribute.String("reason", reason)
+	durationMs := float64(duration.Microseconds()) / 1000.0
+
+	matchAborted.Add(ctx, 1, metric.WithAttributes(attribute.String("client_type", clientType), reasonAttr))
+	matchAbortedQueries.Add(ctx, int64(completed), metric.WithAttributes(reasonAttr, attribute.Bool("completed", true)))
+	matchAbortedQueries.Add(ctx, int64(queryCount-completed), metric.WithAttributes(reasonAttr, attribute.Bool("completed", false)))
+
+	var record log.Record
+	record.SetTimestamp(time.Now())
+	record.SetSeverity(log.SeverityWarn)
+	record.SetBody(log.StringValue("match aborted"))
+	record.AddAttributes(
+		log.String("reason", reason),
+		log.Int("completed_count", completed),
+		log.Int("query_count", queryCount),
+		log.Float64("duration_ms", durationMs),
+		log.String("client_type", clientType),
+	)
+	matchLogger.Emit(ctx, record)
+}
+
 // RegisterClassyFireServiceGauge wires an observable gauge that samples
 // ClassyFire reachability at each metric export: 1 when up, 0 when down
 func RegisterClassyFireServiceGauge(up func() bool) {
@@ -181,6 +238,28 @@ func RegisterClassyFireServiceGauge(up func() bool) {
 	})
 }
 
+// RegisterIndexCacheCounters wires observable counters that sample the cumulative hits
+// and misses of the lookup cache at each metric export
+func RegisterIndexCacheCounters(hits, misses func() int64) {
+	if hits == nil || misses == nil {
+		return
+	}
+	indexCacheOnce.Do(func() {
+		meter := otel.Meter(scopeName)
+		_, err := meter.Int64ObservableCounter("index_cache_lookups_total",
+			metric.WithDescription("Index lookups answered by the lookup cache (hit) or the database (miss)"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(hits(), metric.WithAttributes(attribute.String("result", "hit")))
+				o.Observe(misses(), metric.WithAttributes(attribute.String("result", "miss")))
+				return nil
+			}),
+		)
+		if err != nil {
+			otel.Handle(err)
+		}
+	})
+}
+
 // RecordClassyFireOutcomes counts terminal ClassyFire classification outcomes.
 // Statuses: classified (successful), not_found (no classification exists),
 // failed (unreachable, rate limit give-up, or circuit breaker)
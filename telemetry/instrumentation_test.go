@@ -395,6 +395,56 @@ func TestRecordClassyFireOutcomesSkipsZeroes(t *testing.T) {
 	}
 }
 
+func TestRecordMatchAborted(t *testing.T) {
+	capture.take()
+	RecordMatchAborted(newMatchRequest(true), 40, 100, 2*time.Second, fmt.Errorf("lookup: %w", context.DeadlineExceeded))
+	metrics := collectMetrics(t)
+
+	if v, ct := sumValue(t, metrics, "match_aborted_total"); v != 1 || ct != "frontend" {
+		t.Errorf("match_aborted_total = %d (client_type=%q), want 1 (frontend)", v, ct)
+	}
+
+	sum, ok := metrics["match_aborted_queries_total"].Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("match_aborted_queries_total: unexpected data %#v", metrics["match_aborted_queries_total"].Data)
+	}
+	got := map[bool]int64{}
+	for _, dp := range sum.DataPoints {
+		completed, _ := dp.Attributes.Value(attribute.Key("completed"))
+		reason, _ := dp.Attributes.Value(attribute.Key("reason"))
+		if reason.AsString() != "deadline_exceeded" {
+			t.Errorf("reason = %q, want deadline_exceeded", reason.AsString())
+		}
+		got[completed.AsBool()] = dp.Value
+	}
+	if got[true] != 40 || got[false] != 60 {
+		t.Errorf("completed/skipped queries = %d/%d, want 40/60", got[true], got[false])
+	}
+
+	records := capture.take()
+	if len(records) != 1 {
+		t.Fatalf("got %d log records, want 1", len(records))
+	}
+	attrs := logAttrs(records[0])
+	if attrs["reason"].AsString() != "deadline_exceeded" || attrs["completed_count"].AsInt64() != 40 {
+		t.Errorf("log attributes = %v, want reason deadline_exceeded and completed_count 40", attrs)
+	}
+}
+
+func TestRecordMatchAbortedClientDisconnected(t *testing.T) {
+	capture.take()
+	RecordMatchAborted(newMatchRequest(false), 0, 5, time.Millisecond, context.Canceled)
+	metrics := collectMetrics(t)
+
+	m := metrics["match_aborted_total"].Data.(metricdata.Sum[int64])
+	if len(m.DataPoints) != 1 {
+		t.Fatalf("match_aborted_total has %d datapoints, want 1", len(m.DataPoints))
+	}
+	if reason, _ := m.DataPoints[0].Attributes.Value(attribute.Key("reason")); reason.AsString() != "client_disconnected" {
+		t.Errorf("reason = %q, want client_disconnected", reason.AsString())
+	}
+}
+
 // The observable gauge reports 1 while up and 0 once the provider flips to down,
 // the 0/1 series that drives the uptime timeline and percentage
 func TestClassyFireServiceGauge(t *testing.T) {
@@ -423,6 +473,36 @@ func TestClassyFireServiceGauge(t *testing.T) {
 	}
 }
 
+// The cache counters are observed as cumulative totals, exported as the lookups since
+// the previous collection
+func TestIndexCacheCounters(t *testing.T) {
+	var hits, misses int64 = 3, 1
+	RegisterIndexCacheCounters(func() int64 { return hits }, func() int64 { return misses })
+
+	collectLookups := func() map[string]int64 {
+		t.Helper()
+		metrics := collectMetrics(t)
+		sum, ok := metrics["index_cache_lookups_total"].Data.(metricdata.Sum[int64])
+		if !ok {
+			t.Fatalf("index_cache_lookups_total: unexpected data %#v", metrics["index_cache_lookups_total"].Data)
+		}
+		got := map[string]int64{}
+		for _, dp := range sum.DataPoints {
+			result, _ := dp.Attributes.Value(attribute.Key("result"))
+			got[result.AsString()] = dp.Value
+		}
+		return got
+	}
+
+	if got := collectLookups(); got["hit"] != 3 || got["miss"] != 1 {
+		t.Errorf("lookups = %v, want 3 hits and 1 miss", got)
+	}
+	hits, misses = 10, 2
+	if got := collectLookups(); got["hit"] != 7 || got["miss"] != 1 {
+		t.Errorf("lookups = %v, want 7 hits and 1 miss since the previous collection", got)
+	}
+}
+
 func TestRecordMatchEmptyResults(t *testing.T) {
 	capture.take()
 	// Must not panic or divide by zero.
@@ -154,13 +154,13 @@ document.addEventListener("DOMContentLoaded", () => {
 
   // Download buttons (set up once, always reference current allData)
   document.getElementById("download-csv").addEventListener("click", () => {
-    const hasClassyfire = allData.some(r => r.matches && r.matches.some(m => m.classyfire));
-    let csv = "query,query_type,converted_query,found_match,match_level,error_message,pubchem_cid,inchikey,inchi,smiles,compound_name,molecular_formula,exact_mass,literature_count,patent_count";
+    const hasClassyfire = allData.some(r => allMatches(r).some(m => m.classyfire));
+    let csv = "query,query_type,converted_query,found_match,match_level,error_message,pubchem_cid,inchikey,inchi,smiles,compound_name,molecular_formula,exact_mass,literature_count,patent_count,charge,molecular_weight,xlogp,tpsa,hbond_donor_count,hbond_acceptor_count,heavy_atom_count,source";
     if (hasClassyfire) {
       csv += ",classyfire_kingdom,classyfire_superclass,classyfire_class,classyfire_subclass,classyfire_direct_parent,classyfire_description,classyfire_error";
     }
     csv += "\n";
-    allData.forEach(result => {
+    allData.flatMap(csvResults).forEach(result => {
       if (result.matches && result.matches.length > 0) {
         result.matches.forEach(match => {
           const cf = match.classyfire || {};
@@ -179,7 +179,15 @@ document.addEventListener("DOMContentLoaded", () => {
             csvField(match.molecular_formula),
             csvField(match.exact_mass),
             csvField(match.literature_count),
-            csvField(match.patent_count)
+            csvField(match.patent_count),
+            csvField(match.charge),
+            csvField(match.molecular_weight),
+            csvField(match.xlogp),
+            csvField(match.tpsa),
+            csvField(match.hbond_donor_count),
+            csvField(match.hbond_acceptor_count),
+            csvField(match.heavy_atom_count),
+            csvField(match.source)
           ];
           if (hasClassyfire) {
             row.push(csvField(cf.kingdom), csvField(cf.superclass), csvField(cf.class),
@@ -191,7 +199,9 @@ document.addEventListener("DOMContentLoaded", () => {
         const row = [
           csvField(result.query), csvField(result.query_type), csvField(result.converted_query), csvField(result.found_match),
           csvField(""), csvField(result.error_message),
-          csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField("")
+          csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""),
+          csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""),
+          csvField("")
         ];
         if (hasClassyfire) {
           row.push(csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""), csvField(""));
@@ -237,7 +247,8 @@ document.addEventListener("DOMContentLoaded", () => {
     }
 
     const maxQueryLength = 100000;
-    const queryCount = query.trim().split(/\s+/).filter(Boolean).length;
+    // One query per line, so that names such as "acetic acid" stay whole
+    const queryCount = query.split("\n").filter((line) => line.trim()).length;
     if (queryCount > maxQueryLength) {
       outputLabel.textContent = "Error";
       appliedSettingsLabel.style.display = "none";
@@ -262,7 +273,7 @@ document.addEventListener("DOMContentLoaded", () => {
     const classyfireEnabled = document.getElementById("classyfire-enabled").checked;
     const rdkitConversion = document.getElementById("rdkit-conversion").checked;
     classyfireRequested = classyfireEnabled; // snapshot for displayResults (survives later toggling from the settings panel)
-    let url = "/match?";
+    let url = "/match?split=newline";
     if (!topHitOnly) {
       url += "&top_hit_only=false";
     }
@@ -340,7 +351,7 @@ document.addEventListener("DOMContentLoaded", () => {
             classified = 0;
             currentPage = 1;
             inchikeyIndex = new Map();
-            allData.forEach(r => (r.matches || []).forEach(m => {
+            allData.forEach(r => allMatches(r).forEach(m => {
               // Matches beyond the top three cap already carry a note; don't queue
               // them for live updates or a streamed line would overwrite it
               if (m.classyfire) return;
@@ -462,42 +473,15 @@ function displayResults(data, outputElement, offset = 0) {
       ? `<div class="error-message">${escapeHtml(result.error_message).replace("see documentation", '<a href="/docs#query-types" target="_blank">see documentation</a>')}</div>`
       : "";
 
-    const matchesHtml = (result.matches && result.matches.length > 0)
-      ? `<div class="matches-section">${result.matches.map((match, i) => `
-          ${i > 0 ? "<br>" : ""}
-          <div class="match-item">
-            <div class="match-header">
-              MATCH${result.matches.length > 1 ? ` ${i + 1}` : ""} &mdash;
-              <strong><a href="https://pubchem.ncbi.nlm.nih.gov/compound/${match.identifier}#Known+Use+Information=" target="_blank" style="text-decoration:underline;color:#1a3e68">${escapeHtml(match.compound_name || "Unnamed Compound").toUpperCase()}</a></strong>
-            </div>
-            <hr>
-            <div class="match-details">
-              <div class="match-field"><label>PubChem CID:</label><span class="monospace">${escapeHtml(match.identifier)}</span></div>
-              <div class="match-field"><label>InChIKey:</label><span class="monospace">${escapeHtml(match.inchikey)}</span></div>
-              <div class="match-field"><label>InChI:</label><span class="monospace small-text">${escapeHtml(match.inchi)}</span></div>
-              <div class="match-field"><label>SMILES:</label><span class="monospace small-text">${escapeHtml(match.smiles)}</span></div>
-              <div class="match-field"><label>Compound Name:</label><span class="monospace small-text">${escapeHtml(match.compound_name)}</span></div>
-              <div class="match-field"><label>Mol. Formula:</label><span class="monospace">${escapeHtml(match.molecular_formula)}</span></div>
-              <div class="match-field"><label>Exact Mass:</label><span class="monospace">${match.exact_mass}</span></div>
-              <div class="match-field"><label>Literature Count:</label><span class="monospace">${match.literature_count}</span></div>
-              <div class="match-field"><label>Patent Count:</label><span class="monospace">${match.patent_count}</span></div>
-              ${classyfireRequested ? `
-              <div class="match-field classyfire-heading"><label>Chemical Classification</label></div>
-              ${!match.classyfire ? `<div class="match-field cf-queued"><span>Queued</span><span class="inline-spinner" aria-hidden="true"></span></div>`
-              : match.classyfire.error ? `<div class="match-field"><label>${match.classyfire.error.startsWith("Only the top") ? "Skipped:" : "Error:"}</label><span class="monospace small-text">${escapeHtml(match.classyfire.error)}</span></div>`
-              : (match.classyfire.kingdom || match.classyfire.superclass || match.classyfire.class || match.classyfire.subclass || match.classyfire.direct_parent || match.classyfire.description) ? `
-              ${match.classyfire.kingdom ? `<div class="match-field"><label>Kingdom:</label><span class="monospace">${escapeHtml(match.classyfire.kingdom)}</span></div>` : ""}
-              ${match.classyfire.superclass ? `<div class="match-field"><label>Superclass:</label><span class="monospace">${escapeHtml(match.classyfire.superclass)}</span></div>` : ""}
-              ${match.classyfire.class ? `<div class="match-field"><label>Class:</label><span class="monospace">${escapeHtml(match.classyfire.class)}</span></div>` : ""}
-              ${match.classyfire.subclass ? `<div class="match-field"><label>Subclass:</label><span class="monospace">${escapeHtml(match.classyfire.subclass)}</span></div>` : ""}
-              ${match.classyfire.direct_parent ? `<div class="match-field"><label>Direct Parent:</label><span class="monospace">${escapeHtml(match.classyfire.direct_parent)}</span></div>` : ""}
-              ${match.classyfire.description ? `<div class="match-field"><label>Description:</label><span class="small-text" style="word-break: normal; overflow-wrap: anywhere;">${escapeHtml(match.classyfire.description)}</span></div>` : ""}
-              ` : `<div class="match-field"><span class="monospace small-text">No classification found</span></div>`}
-              ` : ""}
-            </div>
-          </div>`).join("")}
-        </div>`
-      : "";
+    const componentsHtml = (result.components || []).map((component, i) => `
+      <div class="component-item">
+        <div class="component-header">
+          COMPONENT ${i + 1}: <span class="monospace">${escapeHtml(component.query)}</span>
+          <span class="match-status ${getMatchStatusClass(component)}">${getMatchStatusText(component)}</span>
+        </div>
+        ${component.error_message ? `<div class="error-message">${escapeHtml(component.error_message)}</div>` : ""}
+        ${renderMatches(component.matches)}
+      </div>`).join("");
 
     const isConverted = result.query_type === "converted_smiles" && result.converted_query;
     const transId = `trans-${offset + index}`;
@@ -521,7 +505,8 @@ function displayResults(data, outputElement, offset = 0) {
       </div>
       <div class="result-body">
         ${errorHtml}
-        ${matchesHtml}
+        ${renderMatches(result.matches)}
+        ${componentsHtml}
       </div>
     `;
 
@@ -546,6 +531,68 @@ function displayResults(data, outputElement, offset = 0) {
   });
 }
 
+// renderMatches renders the match cards of a result or of a mixture component
+function renderMatches(matches) {
+  return (matches && matches.length > 0)
+    ? `<div class="matches-section">${matches.map((match, i) => `
+        ${i > 0 ? "<br>" : ""}
+        <div class="match-item">
+          <div class="match-header">
+            MATCH${matches.length > 1 ? ` ${i + 1}` : ""} &mdash;
+            <strong>${isPubChem(match)
+              ? `<a href="https://pubchem.ncbi.nlm.nih.gov/compound/${match.identifier}#Known+Use+Information=" target="_blank" style="text-decoration:underline;color:#1a3e68">${escapeHtml(match.compound_name || "Unnamed Compound").toUpperCase()}</a>`
+              : escapeHtml(match.compound_name || "Unnamed Compound").toUpperCase()}</strong>
+          </div>
+          <hr>
+          <div class="match-details">
+            <div class="match-field"><label>${isPubChem(match) ? "PubChem CID" : "Identifier"}:</label><span class="monospace">${escapeHtml(match.identifier)}</span></div>
+            ${match.source ? `<div class="match-field"><label>Source:</label><span class="monospace">${escapeHtml(match.source)}</span></div>` : ""}
+            <div class="match-field"><label>InChIKey:</label><span class="monospace">${escapeHtml(match.inchikey)}</span></div>
+            <div class="match-field"><label>InChI:</label><span class="monospace small-text">${escapeHtml(match.inchi)}</span></div>
+            <div class="match-field"><label>SMILES:</label><span class="monospace small-text">${escapeHtml(match.smiles)}</span></div>
+            <div class="match-field"><label>Compound Name:</label><span class="monospace small-text">${escapeHtml(match.compound_name)}</span></div>
+            <div class="match-field"><label>Mol. Formula:</label><span class="monospace">${escapeHtml(match.molecular_formula)}</span></div>
+            <div class="match-field"><label>Exact Mass:</label><span class="monospace">${match.exact_mass}</span></div>
+            <div class="match-field"><label>Literature Count:</label><span class="monospace">${match.literature_count}</span></div>
+            <div class="match-field"><label>Patent Count:</label><span class="monospace">${match.patent_count}</span></div>
+            ${match.charge != null ? `<div class="match-field"><label>Charge:</label><span class="monospace">${match.charge}</span></div>` : ""}
+            ${match.molecular_weight != null ? `<div class="match-field"><label>Mol. Weight:</label><span class="monospace">${match.molecular_weight}</span></div>` : ""}
+            ${match.xlogp != null ? `<div class="match-field"><label>XLogP:</label><span class="monospace">${match.xlogp}</span></div>` : ""}
+            ${match.tpsa != null ? `<div class="match-field"><label>TPSA:</label><span class="monospace">${match.tpsa} &Aring;&sup2;</span></div>` : ""}
+            ${match.hbond_donor_count != null ? `<div class="match-field"><label>H-Bond Donors:</label><span class="monospace">${match.hbond_donor_count}</span></div>` : ""}
+            ${match.hbond_acceptor_count != null ? `<div class="match-field"><label>H-Bond Acceptors:</label><span class="monospace">${match.hbond_acceptor_count}</span></div>` : ""}
+            ${match.heavy_atom_count != null ? `<div class="match-field"><label>Heavy Atoms:</label><span class="monospace">${match.heavy_atom_count}</span></div>` : ""}
+            ${classyfireRequested ? `
+            <div class="match-field classyfire-heading"><label>Chemical Classification</label></div>
+            ${!match.classyfire ? `<div class="match-field cf-queued"><span>Queued</span><span class="inline-spinner" aria-hidden="true"></span></div>`
+            : match.classyfire.error ? `<div class="match-field"><label>${match.classyfire.error.startsWith("Only the top") ? "Skipped:" : "Error:"}</label><span class="monospace small-text">${escapeHtml(match.classyfire.error)}</span></div>`
+            : (match.classyfire.kingdom || match.classyfire.superclass || match.classyfire.class || match.classyfire.subclass || match.classyfire.direct_parent || match.classyfire.description) ? `
+            ${match.classyfire.kingdom ? `<div class="match-field"><label>Kingdom:</label><span class="monospace">${escapeHtml(match.classyfire.kingdom)}</span></div>` : ""}
+            ${match.classyfire.superclass ? `<div class="match-field"><label>Superclass:</label><span class="monospace">${escapeHtml(match.classyfire.superclass)}</span></div>` : ""}
+            ${match.classyfire.class ? `<div class="match-field"><label>Class:</label><span class="monospace">${escapeHtml(match.classyfire.class)}</span></div>` : ""}
+            ${match.classyfire.subclass ? `<div class="match-field"><label>Subclass:</label><span class="monospace">${escapeHtml(match.classyfire.subclass)}</span></div>` : ""}
+            ${match.classyfire.direct_parent ? `<div class="match-field"><label>Direct Parent:</label><span class="monospace">${escapeHtml(match.classyfire.direct_parent)}</span></div>` : ""}
+            ${match.classyfire.description ? `<div class="match-field"><label>Description:</label><span class="small-text" style="word-break: normal; overflow-wrap: anywhere;">${escapeHtml(match.classyfire.description)}</span></div>` : ""}
+            ` : `<div class="match-field"><span class="monospace small-text">No classification found</span></div>`}
+            ` : ""}
+          </div>
+        </div>`).join("")}
+      </div>`
+    : "";
+}
+
+// Mixtures have no matches of their own, they are in their components
+function allMatches(result) {
+  return [...(result.matches || []), ...(result.components || []).flatMap(c => c.matches || [])];
+}
+
+// csvResults are the CSV rows of a result: one per component of a mixture, with the
+// fragment as converted query, like the server's CSV
+function csvResults(result) {
+  if (!result.components) return [result];
+  return result.components.map(c => ({ ...c, query: result.query, query_type: result.query_type, converted_query: c.query }));
+}
+
 function escapeHtml(text) {
   if (!text) return "";
   const div = document.createElement("div");
@@ -590,6 +637,11 @@ function formatQueryType(queryType) {
   }
 }
 
+// Matches without a source come from a server without libraries, so from PubChem
+function isPubChem(match) {
+  return !match.source || match.source === "pubchem";
+}
+
 function countNumMatches(data) {
   return data.filter(r => r.found_match).length;
 }